@@ -0,0 +1,350 @@
+package internal
+
+import (
+	"archive/tar"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/samber/lo"
+)
+
+// An archive is a zstd compressed tar file, holding the manifest, optionally its signature, and then
+// the slots of one profile. Each slot is stored under slots/<index>, in the order of the manifest paths
+const (
+	archiveManifestFile  = "manifest.json"
+	archiveSignatureFile = "manifest.sig"
+	archiveSlotsDir      = "slots"
+	archiveFormatVersion = 1
+)
+
+// ArchiveManifest describes the content of a profile archive, so it can be verified before importing
+type ArchiveManifest struct {
+	FormatVersion int           `json:"formatVersion"`
+	Profile       string        `json:"profile"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	Paths         []ArchivePath `json:"paths"`
+}
+
+// ArchivePath is the slot of the profile for one managed path
+type ArchivePath struct {
+	// Path is the managed path, relative to the home directory (~/...) where possible
+	Path  string        `json:"path"`
+	Files []ArchiveFile `json:"files"`
+}
+
+// ArchiveFile is a file, directory or symlink of a slot. The slot itself has the name "."
+type ArchiveFile struct {
+	Name string `json:"name"`
+	// Type is one of file, dir or symlink
+	Type   string      `json:"type"`
+	Mode   fs.FileMode `json:"mode"`
+	Target string      `json:"target,omitempty"`
+	Sha256 string      `json:"sha256,omitempty"`
+}
+
+func (p ArchivePath) slotType() string {
+	return p.Files[0].Type
+}
+
+// archiveSlotName is the name of the slot of the i:th manifest path within the archive
+func archiveSlotName(i int) string {
+	return path.Join(archiveSlotsDir, fmt.Sprint(i))
+}
+
+// newArchiveManifest hashes the slots of a profile, given as managed path -> slot directory or file
+func newArchiveManifest(profile string, paths []string, slots []string, now time.Time) (ArchiveManifest, error) {
+	manifest := ArchiveManifest{FormatVersion: archiveFormatVersion, Profile: profile, CreatedAt: now.UTC()}
+	for i, slot := range slots {
+		archivePath := ArchivePath{Path: toConfigPath(paths[i])}
+		err := filepath.WalkDir(slot, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(slot, p)
+			if err != nil {
+				return err
+			}
+			file, ok, err := archiveFileOf(p, filepath.ToSlash(rel), d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				infof("WARNING: Skipping '%s', only files, directories and symlinks can be exported\n", p)
+				return nil
+			}
+			archivePath.Files = append(archivePath.Files, file)
+			return nil
+		})
+		if err != nil {
+			return ArchiveManifest{}, fmt.Errorf("failed to read slot '%s': %w", slot, err)
+		}
+		manifest.Paths = append(manifest.Paths, archivePath)
+	}
+	return manifest, nil
+}
+
+func archiveFileOf(p string, name string, d fs.DirEntry) (ArchiveFile, bool, error) {
+	info, err := d.Info()
+	if err != nil {
+		return ArchiveFile{}, false, err
+	}
+	file := ArchiveFile{Name: name, Mode: info.Mode().Perm()}
+	switch {
+	case info.Mode().IsDir():
+		file.Type = "dir"
+	case info.Mode()&fs.ModeSymlink != 0:
+		file.Type = "symlink"
+		if file.Target, err = os.Readlink(p); err != nil {
+			return ArchiveFile{}, false, err
+		}
+	case info.Mode().IsRegular():
+		file.Type = "file"
+		if file.Sha256, err = sha256File(p); err != nil {
+			return ArchiveFile{}, false, err
+		}
+	default:
+		return ArchiveFile{}, false, nil
+	}
+	return file, true, nil
+}
+
+func sha256File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeArchive writes the manifest and the slots it describes, signing the manifest if a key is given
+func writeArchive(w io.Writer, manifest ArchiveManifest, slots []string, key ed25519.PrivateKey) error {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, archiveManifestFile, manifestBytes); err != nil {
+		return err
+	}
+	if key != nil {
+		manifestSignature, err := signManifest(key, manifestBytes)
+		if err != nil {
+			return err
+		}
+		signature, err := json.MarshalIndent(manifestSignature, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, archiveSignatureFile, signature); err != nil {
+			return err
+		}
+	}
+
+	for i, archivePath := range manifest.Paths {
+		for _, file := range archivePath.Files {
+			if err := writeArchiveFile(tw, path.Join(archiveSlotName(i), file.Name), filepath.Join(slots[i], filepath.FromSlash(file.Name)), file); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(data))}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeArchiveFile(tw *tar.Writer, name string, src string, file ArchiveFile) error {
+	header := &tar.Header{Name: name, Mode: int64(file.Mode)}
+	switch file.Type {
+	case "dir":
+		header.Typeflag = tar.TypeDir
+		return tw.WriteHeader(header)
+	case "symlink":
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = file.Target
+		return tw.WriteHeader(header)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header.Typeflag = tar.TypeReg
+	header.Size = info.Size()
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// readArchive extracts an archive into dir, which must exist, verifying every entry against the
+// manifest. The slot of the i:th manifest path ends up in dir/slots/<i>. If verify is given, it is
+// called with the manifest and its signature, nil if unsigned, before anything is extracted
+func readArchive(r io.Reader, dir string, verify func(manifest []byte, signature *ArchiveSignature) error) (ArchiveManifest, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != archiveManifestFile {
+		return ArchiveManifest{}, fmt.Errorf("not a profs archive, expected it to start with %s", archiveManifestFile)
+	}
+	manifestBytes, err := io.ReadAll(tr)
+	if err != nil {
+		return ArchiveManifest{}, fmt.Errorf("failed to read archive manifest: %w", err)
+	}
+	manifest := ArchiveManifest{}
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return ArchiveManifest{}, fmt.Errorf("failed to parse archive manifest: %w", err)
+	}
+
+	header, err = tr.Next()
+	var signature *ArchiveSignature
+	if err == nil && header.Name == archiveSignatureFile {
+		signature = &ArchiveSignature{}
+		if err := json.NewDecoder(tr).Decode(signature); err != nil {
+			return ArchiveManifest{}, fmt.Errorf("failed to parse archive signature: %w", err)
+		}
+		header, err = tr.Next()
+	}
+	if verify != nil {
+		if err := verify(manifestBytes, signature); err != nil {
+			return ArchiveManifest{}, err
+		}
+	}
+
+	if manifest.FormatVersion != archiveFormatVersion {
+		return ArchiveManifest{}, fmt.Errorf("unsupported archive format version %d, expected %d", manifest.FormatVersion, archiveFormatVersion)
+	}
+
+	expected := map[string]ArchiveFile{}
+	symlinks := map[string]bool{}
+	for i, archivePath := range manifest.Paths {
+		if len(archivePath.Files) == 0 {
+			return ArchiveManifest{}, fmt.Errorf("archive manifest has no files for path '%s'", archivePath.Path)
+		}
+		for _, file := range archivePath.Files {
+			name := path.Join(archiveSlotName(i), file.Name)
+			expected[name] = file
+			if file.Type == "symlink" {
+				symlinks[name] = true
+			}
+		}
+	}
+
+	type extractedDir struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirs []extractedDir
+	for ; !errors.Is(err, io.EOF); header, err = tr.Next() {
+		if err != nil {
+			return ArchiveManifest{}, fmt.Errorf("failed to read archive: %w", err)
+		}
+		name := path.Clean(header.Name)
+		file, found := expected[name]
+		if !found || !filepath.IsLocal(name) {
+			return ArchiveManifest{}, fmt.Errorf("archive contains '%s', which is not in its manifest", header.Name)
+		}
+		delete(expected, name)
+		// never write through a symlink extracted before
+		for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+			if symlinks[parent] {
+				return ArchiveManifest{}, fmt.Errorf("archive entry '%s' is below the symlink '%s'", header.Name, parent)
+			}
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := extractArchiveFile(tr, header, dst, file); err != nil {
+			return ArchiveManifest{}, fmt.Errorf("failed to extract '%s': %w", header.Name, err)
+		}
+		if file.Type == "dir" {
+			dirs = append(dirs, extractedDir{path: dst, mode: file.Mode})
+		}
+	}
+
+	if len(expected) > 0 {
+		return ArchiveManifest{}, fmt.Errorf("archive is missing %s from its manifest", strings.Join(lo.Keys(expected), ", "))
+	}
+
+	// directories may be read-only, so their modes are applied once their content is in place
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return ArchiveManifest{}, err
+		}
+	}
+	return manifest, nil
+}
+
+func extractArchiveFile(tr *tar.Reader, header *tar.Header, dst string, file ArchiveFile) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	switch {
+	case file.Type == "dir" && header.Typeflag == tar.TypeDir:
+		return os.MkdirAll(dst, 0700)
+	case file.Type == "symlink" && header.Typeflag == tar.TypeSymlink:
+		if header.Linkname != file.Target {
+			return fmt.Errorf("symlink target '%s' does not match the manifest", header.Linkname)
+		}
+		return os.Symlink(file.Target, dst)
+	case file.Type == "file" && header.Typeflag == tar.TypeReg:
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, file.Mode)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(f, h), tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != file.Sha256 {
+			return fmt.Errorf("checksum mismatch, expected %s, got %s", file.Sha256, sum)
+		}
+		return nil
+	default:
+		return fmt.Errorf("entry type does not match the manifest, expected %s", file.Type)
+	}
+}
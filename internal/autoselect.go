@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samber/lo"
+)
+
+// ProfileMarkerFile names the profile (or mix) to use for a directory and everything below it,
+// similar to .nvmrc or .tool-versions
+const ProfileMarkerFile = ".profs-profile"
+
+// ProfileSelection is a profile (or mix) selected automatically for a directory
+type ProfileSelection struct {
+	Profile string `json:"profile"`
+	// Source describes where the selection came from, e.g. the marker file path
+	Source string `json:"source"`
+}
+
+// findProfileMarker walks from dir up to the filesystem root, returning the profile named by the nearest marker file
+func findProfileMarker(dir string) (ProfileSelection, bool, error) {
+	dir, err := toAbsPath(dir)
+	if err != nil {
+		return ProfileSelection{}, false, err
+	}
+
+	for {
+		markerPath := filepath.Join(dir, ProfileMarkerFile)
+		profile, err := readProfileMarker(markerPath)
+		if err == nil {
+			return ProfileSelection{Profile: profile, Source: markerPath}, true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return ProfileSelection{}, false, err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ProfileSelection{}, false, nil
+		}
+		dir = parent
+	}
+}
+
+// readProfileMarker returns the first line of a marker file that is neither empty nor a # comment
+func readProfileMarker(markerPath string) (string, error) {
+	f, err := os.Open(markerPath)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("marker file %s does not name a profile", markerPath)
+}
+
+// SelectProfileForDir determines which profile should be used in a directory, if any.
+// The nearest marker file wins, otherwise the first rule matching a remote of the enclosing git repository
+func (g GlobalConfig) SelectProfileForDir(dir string) (ProfileSelection, bool, error) {
+	selection, found, err := findProfileMarker(dir)
+	if err != nil || found {
+		return selection, found, err
+	}
+	return g.selectProfileByRemote(dir)
+}
+
+// selectProfileByRemote matches the remotes of the git repository enclosing dir against the configured rules
+func (g GlobalConfig) selectProfileByRemote(dir string) (ProfileSelection, bool, error) {
+	if len(g.Rules) == 0 {
+		return ProfileSelection{}, false, nil
+	}
+
+	_, gitDir, found, err := findGitDir(dir)
+	if err != nil || !found {
+		return ProfileSelection{}, false, err
+	}
+
+	remotes, err := readGitRemotes(gitDir)
+	if err != nil {
+		return ProfileSelection{}, false, err
+	}
+
+	for _, rule := range g.Rules {
+		for _, remote := range remotes {
+			if remoteMatches(rule.Remote, remote.URL) {
+				return ProfileSelection{
+					Profile: rule.Profile,
+					Source:  fmt.Sprintf("remote %s (%s) matches rule %s", remote.Name, remote.URL, rule.Remote),
+				}, true, nil
+			}
+		}
+	}
+	return ProfileSelection{}, false, nil
+}
+
+// IsActive checks if the given profile or mix is the one currently active. A profile is also active when
+// other paths are on other profiles only on purpose, switched by a partial 'set' or not managed by the profile
+func (g GlobalConfig) IsActive(name string) bool {
+	if mix, found := g.ActiveMixName(); found && mix == name {
+		return true
+	}
+	active := false
+	for _, p := range g.Paths {
+		switch {
+		case p.ResolvedTgt == nil || g.IsSparse(name, p):
+			// paths the profile doesn't manage stay on whatever profile they are on
+			continue
+		case p.OverrideProfile != "":
+			if p.ResolvedTgt.Name != p.OverrideProfile {
+				return false
+			}
+		case p.ResolvedTgt.Name != name:
+			return false
+		default:
+			active = true
+		}
+	}
+	return active
+}
+
+// IsKnownProfileOrMix checks if a name refers to a detected profile or a configured mix
+func (g GlobalConfig) IsKnownProfileOrMix(name string) bool {
+	return lo.Contains(g.DetectedProfileNames(), name) || lo.Contains(g.MixNames(), name)
+}
@@ -11,11 +11,13 @@ import (
 	"strings"
 )
 
-func AddCmd(cmdName string, gc GlobalConfig) *cobra.Command {
+func AddCmd(cmdName string, lazyGc *LazyGlobalConfig) *cobra.Command {
 
 	var params struct {
-		Path    string               `positional:"true" description:"Path to add"`
-		Profile string `short:"p" name:"profile" optional:"true" description:"Profile to use (defaults to active profile)"`
+		Path        string   `positional:"true" description:"Path to add"`
+		Profile     string   `short:"p" name:"profile" optional:"true" description:"Profile to use (defaults to active profile)"`
+		SkipProfile []string `descr:"Profiles that don't manage this path, no slots are created for them (repeatable)" optional:"true"`
+		DryRun      bool     `descr:"Print the planned filesystem operations without performing them" default:"false"`
 	}
 
 	return boa.Cmd{
@@ -24,10 +26,12 @@ func AddCmd(cmdName string, gc GlobalConfig) *cobra.Command {
 		Params:      &params,
 		ParamEnrich: paramEnricherDefault,
 		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			fsys := newFsOps(params.DryRun)
 
 			profileName := params.Profile
 			if profileName == "" {
-				activeProfiles := gc.ActiveProfileNames()
+				activeProfiles := gc.BaseProfileNames()
 				switch len(activeProfiles) {
 				case 0:
 					ExitWithMsg(1, "No active profile found and no profile specified. Don't know how to add path.")
@@ -38,126 +42,178 @@ func AddCmd(cmdName string, gc GlobalConfig) *cobra.Command {
 				}
 			}
 
-			// check if it under the current user's home directory
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				ExitWithMsg(1, fmt.Sprintf("Failed to get user home directory, error: %v", err))
-			}
-
-			// check that the path is absolute
-			path := params.Path
-			if !filepath.IsAbs(path) {
-				path, err = filepath.Abs(path)
-				if err != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", path, err))
+			for _, skipped := range params.SkipProfile {
+				if skipped == profileName {
+					ExitWithMsg(1, fmt.Sprintf("Cannot skip profile '%s', the path is added to it", skipped))
+				}
+				if !lo.Contains(gc.DetectedProfileNames(), skipped) {
+					ExitWithMsg(1, fmt.Sprintf("Profile '%s' does not exist", skipped))
 				}
 			}
 
-			rawConfig := LoadGlobalConfRaw()
-			if lo.ContainsBy(rawConfig.Paths, func(item string) bool {
+			rawConfig := loadGlobalConfRawOrExit()
+			path, profsDir := addPath(fsys, gc, &rawConfig, params.Path, profileName, params.SkipProfile, &rollback{})
+			fsys.SaveConfig(rawConfig)
+			fsys.RefreshState()
 
-				a, errA := filepath.Abs(item)
-				if errA != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", item, errA))
-				}
+			printResult(AddResult{
+				resultHeader: newResultHeader("add"),
+				Path:         path,
+				Profile:      profileName,
+				StorageDir:   profsDir,
+			}, nil)
 
-				b, errB := filepath.Abs(path)
-				if errB != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", path, errB))
-				}
+		},
+	}.ToCobra()
+}
 
-				return a == b
-			}) {
-				ExitWithMsg(1, fmt.Sprintf("Path '%s' already exists in configuration, aborting", path))
-			}
+// addPath moves a path into the slot of a profile and links it, creating empty slots for all other
+// profiles managing the path, and adds it to the config. Returns the absolute path and its storage directory.
+// The filesystem changes are recorded in undo, which is rolled back along with them on failure
+func addPath(fsys fsOps, gc GlobalConfig, rawConfig *GlobalConfigRaw, srcPath string, profileName string, skipProfiles []string, undo *rollback) (string, string) {
+	// check if it under the current user's home directory
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		exitAfterRollback(undo, fmt.Sprintf("Failed to get user home directory, error: %v", err))
+	}
 
-			// create a .profs directory if it doesn't exist
-			profsDir := path + ".profs"
-			err = os.MkdirAll(profsDir, 0755)
-			if err != nil {
-				ExitWithMsg(1, fmt.Sprintf("Failed to create .profs directory at '%s', error: %v", profsDir, err))
-			}
+	// check that the path is absolute
+	path := srcPath
+	if !filepath.IsAbs(path) {
+		path, err = filepath.Abs(path)
+		if err != nil {
+			exitAfterRollback(undo, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", path, err))
+		}
+	}
 
-			// Check if the path is already managed, i.e. is a symlink
-			if isSymlink(path) {
-				// Check fi it points to a profile in the .profs directory
-				target, err := os.Readlink(path)
-				if err != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to read symlink at '%s', error: %v", path, err))
-				}
+	if lo.ContainsBy(rawConfig.Paths, func(item string) bool {
 
-				parentOfTarget, err := filepath.Abs(filepath.Dir(target))
-				if err != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to get parent directory of symlink target '%s', error: %v", target, err))
-				}
-				if parentOfTarget == profsDir {
-					slog.Warn("Path is already a symlink managed by profs (=is a symlink), skipping", "path", path)
-				} else {
-					ExitWithMsg(1, fmt.Sprintf(
-						"Path is already a symlink, but does not point to a profile in .profs, aborting\n"+
-							"  Path: %s\n"+
-							"  Parent of target: %s\n"+
-							"  Expected parent: %s",
-						path,
-						parentOfTarget,
-						profsDir,
-					))
-				}
-			} else {
-
-				// Move the existing directory to .profs, and rename it to the profile name
-				newPath := profsDir + "/" + profileName
-				if !fileOrDirExists(newPath) {
-
-					// Check that the path exists
-					if !fileOrDirExists(path) {
-						slog.Warn("Path to add does not exist, creating it", "path", path)
-						err := os.MkdirAll(path, 0755)
-						if err != nil {
-							ExitWithMsg(1, fmt.Sprintf("Failed to create path '%s', error: %v", path, err))
-						}
-					}
-
-					err = os.Rename(path, newPath)
-					if err != nil {
-						ExitWithMsg(1, fmt.Sprintf("Failed to move existing directory to .profs, error: %v", err))
-					}
-				} else {
-					slog.Warn("Path already exists in .profs, skipping move", "path", newPath)
-				}
+		a, errA := filepath.Abs(item)
+		if errA != nil {
+			exitAfterRollback(undo, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", item, errA))
+		}
 
-				// Create a symlink from the new path to the original path
-				err = os.Symlink(newPath, path)
-				if err != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to create symlink from '%s' to '%s', error: %v", newPath, path, err))
-				}
+		b, errB := filepath.Abs(path)
+		if errB != nil {
+			exitAfterRollback(undo, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", path, errB))
+		}
+
+		return a == b
+	}) {
+		exitAfterRollback(undo, fmt.Sprintf("Path '%s' already exists in configuration, aborting", path))
+	}
 
+	// create a .profs directory if it doesn't exist
+	profsDir := rawConfig.newStorageDirFor(path)
+	if isSymlinkOrExit(path) {
+		// a path already managed by profs (e.g. after a reset) keeps its existing storage
+		if target, err := os.Readlink(path); err == nil {
+			existingDir := filepath.Dir(target)
+			if pathsAreEqual(existingDir, siblingStorageDir(path)) || filepath.Base(existingDir) == encodeStoragePath(path) {
+				profsDir = existingDir
 			}
+		}
+	}
+	if !fileOrDirExistsOrExit(profsDir) {
+		err = fsys.MkdirAll(profsDir, 0755)
+		if err != nil {
+			exitAfterRollback(undo, fmt.Sprintf("Failed to create .profs directory at '%s', error: %v", profsDir, err))
+		}
+		undo.add(func() error { return fsys.Remove(profsDir) })
+	}
 
-			// create directories for all other profiles that we have
-			profiles := gc.DetectedProfileNames()
-			for _, profile := range profiles {
-				if profile == profileName {
-					continue // skip the profile we just added
+	// Check if the path is already managed, i.e. is a symlink
+	if isSymlinkOrExit(path) {
+		// Check fi it points to a profile in the .profs directory
+		target, err := os.Readlink(path)
+		if err != nil {
+			exitAfterRollback(undo, fmt.Sprintf("Failed to read symlink at '%s', error: %v", path, err))
+		}
+
+		parentOfTarget, err := filepath.Abs(filepath.Dir(target))
+		if err != nil {
+			exitAfterRollback(undo, fmt.Sprintf("Failed to get parent directory of symlink target '%s', error: %v", target, err))
+		}
+		if parentOfTarget == profsDir {
+			slog.Warn("Path is already a symlink managed by profs (=is a symlink), skipping", "path", path)
+		} else {
+			exitAfterRollback(undo, fmt.Sprintf(
+				"Path is already a symlink, but does not point to a profile in .profs, aborting\n"+
+					"  Path: %s\n"+
+					"  Parent of target: %s\n"+
+					"  Expected parent: %s",
+				path,
+				parentOfTarget,
+				profsDir,
+			))
+		}
+	} else {
+
+		// Move the existing directory to .profs, and rename it to the profile name
+		newPath := profsDir + "/" + profileName
+		if !fileOrDirExistsOrExit(newPath) {
+
+			// Check that the path exists
+			if !fileOrDirExistsOrExit(path) {
+				slog.Warn("Path to add does not exist, creating it", "path", path)
+				err := fsys.MkdirAll(path, 0755)
+				if err != nil {
+					exitAfterRollback(undo, fmt.Sprintf("Failed to create path '%s', error: %v", path, err))
 				}
+				undo.add(func() error { return fsys.Remove(path) })
+			}
 
-				profilePath := filepath.Join(profsDir, profile)
-				if !fileOrDirExists(profilePath) {
-					err = os.MkdirAll(profilePath, 0755)
-					if err != nil {
-						ExitWithMsg(1, fmt.Sprintf("Failed to create profile directory '%s', error: %v", profilePath, err))
-					}
-				}
+			err = fsys.Rename(path, newPath)
+			if err != nil {
+				exitAfterRollback(undo, fmt.Sprintf("Failed to move existing directory to .profs, error: %v", err))
 			}
+			undo.add(func() error { return fsys.Rename(newPath, path) })
+		} else {
+			slog.Warn("Path already exists in .profs, skipping move", "path", newPath)
+		}
+
+		// Create a symlink from the new path to the original path
+		err = fsys.Symlink(newPath, path)
+		if err != nil {
+			exitAfterRollback(undo, fmt.Sprintf("Failed to create symlink from '%s' to '%s', error: %v", newPath, path, err))
+		}
+		undo.add(func() error { return fsys.Remove(path) })
+
+	}
+
+	// the profile the path is added to manages it, whatever was declared before
+	rawConfig.setSparse(profileName, path, false)
+	for _, skipped := range skipProfiles {
+		rawConfig.setSparse(skipped, path, true)
+	}
 
-			// Add the new path to the configuration file
-			storedPath := path
-			if strings.HasPrefix(storedPath, homeDir) {
-				storedPath = strings.ReplaceAll(storedPath, homeDir, "~")
+	// create directories for all other profiles that we have, except those not managing the path
+	profiles := gc.DetectedProfileNames()
+	for _, profile := range profiles {
+		if profile == profileName {
+			continue // skip the profile we just added
+		}
+		if rawConfig.isSparse(profile, path) {
+			infof("Profile '%s' does not manage path '%s', not creating a slot for it\n", profile, path)
+			continue
+		}
+
+		profilePath := filepath.Join(profsDir, profile)
+		if !fileOrDirExistsOrExit(profilePath) {
+			err = fsys.MkdirAll(profilePath, 0755)
+			if err != nil {
+				exitAfterRollback(undo, fmt.Sprintf("Failed to create profile directory '%s', error: %v", profilePath, err))
 			}
-			rawConfig.Paths = append(rawConfig.Paths, storedPath)
-			SaveGlobalConfRaw(rawConfig)
+			undo.add(func() error { return fsys.Remove(profilePath) })
+		}
+	}
 
-		},
-	}.ToCobra()
+	// Add the new path to the configuration file
+	storedPath := path
+	if strings.HasPrefix(storedPath, homeDir) {
+		storedPath = strings.ReplaceAll(storedPath, homeDir, "~")
+	}
+	rawConfig.Paths = append(rawConfig.Paths, storedPath)
+	rawConfig.setStorageDir(path, profsDir)
+	return path, profsDir
 }
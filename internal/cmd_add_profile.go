@@ -10,11 +10,13 @@ import (
 	"strings"
 )
 
-func AddProfileCmd(gc GlobalConfig) *cobra.Command {
+func AddProfileCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
 
 	var params struct {
-		Name         string `positional:"true" description:"Name of the profile to add"`
-		CopyExisting bool   `name:"copy-existing" description:"If set, copy existing profiles to the new profile instead of creating an empty one" default:"false"`
+		Name         string   `positional:"true" description:"Name of the profile to add"`
+		CopyExisting bool     `name:"copy-existing" description:"If set, copy existing profiles to the new profile instead of creating an empty one" default:"false"`
+		SkipPath     []string `descr:"Paths the new profile doesn't manage, no slots are created for them (repeatable)" optional:"true"`
+		DryRun       bool     `descr:"Print the planned filesystem operations without performing them" default:"false"`
 	}
 
 	return boa.Cmd{
@@ -23,6 +25,12 @@ func AddProfileCmd(gc GlobalConfig) *cobra.Command {
 		Params:      &params,
 		ParamEnrich: paramEnricherDefault,
 		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			fsys := newFsOps(params.DryRun)
+
+			if err := validateProfileName(params.Name); err != nil {
+				ExitWithMsg(1, err.Error())
+			}
 
 			// Current profiles must not collide with the name of the profile to add
 			if lo.ContainsBy(gc.DetectedProfileNames(), func(item string) bool {
@@ -31,16 +39,24 @@ func AddProfileCmd(gc GlobalConfig) *cobra.Command {
 				ExitWithMsg(1, fmt.Sprintf("Profile name '%s' already exists, please choose a different name", params.Name))
 			}
 
+			// Record the paths the profile doesn't manage, also keeping what was declared for the name before
+			rawConfig := loadGlobalConfRawOrExit()
+			for _, path := range selectPaths(gc, params.SkipPath, nil) {
+				rawConfig.setSparse(params.Name, path.SrcPath, true)
+			}
+			paths := lo.Reject(gc.Paths, func(p Path, _ int) bool { return rawConfig.isSparse(params.Name, p.SrcPath) })
+
 			// Go through all the paths and add the profile to each of them
 			// First check they are all valid
-			for _, path := range gc.Paths {
-				if path.Status != StatusOk {
+			for _, path := range paths {
+				if path.Status != StatusOk && path.Status != StatusEmpty {
 					ExitWithMsg(1, fmt.Sprintf("Cannot add profile '%s' to path '%s' because it is not valid: %s, aborting", params.Name, path.SrcPath, path.Status))
 				}
 			}
 
 			// Then add the profile to each path
-			for _, path := range gc.Paths {
+			var slots []string
+			for _, path := range paths {
 				// create a new empty dir in the companion profs directory
 				profsDir, err := path.ProfsDir()
 				if err != nil {
@@ -58,11 +74,11 @@ func AddProfileCmd(gc GlobalConfig) *cobra.Command {
 					currentProfile := currentProfiles[0]
 					currentProfilePath := filepath.Join(profsDir, currentProfile)
 
-					// if it's a file, copy it, if its a dir, use os.CopyFS
+					// if it's a file, copy it, if its a dir, copy the whole dir
 					if stat, err := os.Stat(currentProfilePath); err != nil {
 						ExitWithMsg(1, fmt.Sprintf("Failed to stat current profile '%s' for path '%s': %v", currentProfile, path.SrcPath, err))
 					} else if stat.IsDir() {
-						err = os.CopyFS(newProfilePath, os.DirFS(currentProfilePath))
+						err = fsys.CopyDir(currentProfilePath, newProfilePath)
 						if err != nil {
 							ExitWithMsg(1, fmt.Sprintf("Failed to copy existing profile '%s' to new profile '%s' for path '%s': %v", currentProfile, params.Name, path.SrcPath, err))
 						}
@@ -71,19 +87,30 @@ func AddProfileCmd(gc GlobalConfig) *cobra.Command {
 						if err != nil {
 							ExitWithMsg(1, fmt.Sprintf("Failed to read current profile file '%s' for path '%s': %v", currentProfilePath, path.SrcPath, err))
 						}
-						err = os.WriteFile(newProfilePath, fileBytes, 0644)
+						err = fsys.WriteFile(newProfilePath, fileBytes, 0644)
 						if err != nil {
 							ExitWithMsg(1, fmt.Sprintf("Failed to write new profile file '%s' for path '%s': %v", newProfilePath, path.SrcPath, err))
 						}
 					}
 				} else {
-					err = os.MkdirAll(newProfilePath, 0755)
+					err = fsys.MkdirAll(newProfilePath, 0755)
 					if err != nil {
 						ExitWithMsg(1, fmt.Sprintf("Failed to create profile directory '%s' for path '%s': %v", newProfilePath, path.SrcPath, err))
 					}
 				}
+				slots = append(slots, newProfilePath)
+			}
+
+			if len(params.SkipPath) > 0 {
+				fsys.SaveConfig(rawConfig)
 			}
+			fsys.RefreshState()
 
+			printResult(AddProfileResult{
+				resultHeader: newResultHeader("add-profile"),
+				Profile:      params.Name,
+				Slots:        orEmpty(slots),
+			}, nil)
 		},
 	}.ToCobra()
 }
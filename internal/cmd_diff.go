@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+// maxTextDiffSize is the size up to which changed text files are shown as unified diffs
+const maxTextDiffSize = 64 * 1024
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+func DiffCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		From string   `positional:"true" descr:"Profile to compare from"`
+		To   string   `positional:"true" descr:"Profile to compare to"`
+		Path []string `descr:"Only compare the slots of these paths (repeatable)" optional:"true"`
+	}
+
+	return boa.Cmd{
+		Use:           "diff",
+		Short:         "Show the files added, removed and changed from one profile to another, across all managed paths",
+		Params:        &params,
+		ParamEnrich:   paramEnricherDefault,
+		ValidArgsFunc: lazyGc.completeProfiles,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			for _, profile := range []string{params.From, params.To} {
+				if !lo.Contains(gc.DetectedProfileNames(), profile) {
+					ExitWithMsg(1, fmt.Sprintf("Profile '%s' not found", profile))
+				}
+				exitIfSealed(gc, profile)
+			}
+			paths := gc.Paths
+			if len(params.Path) > 0 {
+				paths = selectPaths(gc, params.Path, nil)
+			}
+
+			var results []DiffPathResult
+			for _, p := range paths {
+				res, err := diffSlots(p, params.From, params.To)
+				if err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to compare the slots of path %s: %v", p.SrcPath, err))
+				}
+				if res.OnlyIn != "" || len(res.Files) > 0 {
+					results = append(results, res)
+				}
+			}
+
+			printResult(DiffResult{
+				resultHeader: newResultHeader("diff"),
+				From:         params.From,
+				To:           params.To,
+				Paths:        orEmpty(results),
+			}, func() {
+				if len(results) == 0 {
+					fmt.Printf("No differences between %s and %s\n", params.From, params.To)
+				}
+				signs := map[string]string{"added": "+", "removed": "-", "changed": "~"}
+				for _, res := range results {
+					if res.OnlyIn != "" {
+						fmt.Printf("%s: only in %s\n", res.Path, res.OnlyIn)
+						continue
+					}
+					fmt.Printf("%s:\n", res.Path)
+					for _, f := range res.Files {
+						fmt.Printf("  %s %s", signs[f.Change], f.File)
+						if f.Detail != "" {
+							fmt.Printf(" (%s)", f.Detail)
+						}
+						fmt.Println()
+						for _, line := range splitLines(f.Diff) {
+							fmt.Printf("    %s\n", line)
+						}
+					}
+				}
+			})
+		},
+	}.ToCobra()
+}
+
+// diffSlots compares the slots of two profiles for a path
+func diffSlots(p Path, from string, to string) (DiffPathResult, error) {
+	res := DiffPathResult{Path: toConfigPath(p.SrcPath), Files: []DiffFileResult{}}
+	fromSlot, fromFound := lo.Find(p.DetectedProfs, func(prof DetectedProfile) bool { return prof.Name == from })
+	toSlot, toFound := lo.Find(p.DetectedProfs, func(prof DetectedProfile) bool { return prof.Name == to })
+	switch {
+	case !fromFound && !toFound:
+		return res, nil
+	case !toFound:
+		res.OnlyIn = from
+		return res, nil
+	case !fromFound:
+		res.OnlyIn = to
+		return res, nil
+	}
+
+	manifest, err := newArchiveManifest("", []string{p.SrcPath, p.SrcPath}, []string{fromSlot.Path, toSlot.Path}, time.Now())
+	if err != nil {
+		return res, err
+	}
+	fromFiles := lo.KeyBy(manifest.Paths[0].Files, func(f ArchiveFile) string { return f.Name })
+	toFiles := lo.KeyBy(manifest.Paths[1].Files, func(f ArchiveFile) string { return f.Name })
+	// file slots are shown by the name of the managed path
+	displayName := func(name string) string {
+		return lo.Ternary(name == ".", filepath.Base(p.SrcPath), name)
+	}
+
+	for _, f := range manifest.Paths[0].Files {
+		if _, found := toFiles[f.Name]; !found {
+			res.Files = append(res.Files, DiffFileResult{File: displayName(f.Name), Change: "removed", Kind: fileDiffKind(f, displayName(f.Name), filepath.Join(fromSlot.Path, filepath.FromSlash(f.Name)))})
+		}
+	}
+	for _, f := range manifest.Paths[1].Files {
+		old, found := fromFiles[f.Name]
+		switch {
+		case !found:
+			res.Files = append(res.Files, DiffFileResult{File: displayName(f.Name), Change: "added", Kind: fileDiffKind(f, displayName(f.Name), filepath.Join(toSlot.Path, filepath.FromSlash(f.Name)))})
+		case old != f:
+			change, err := diffFile(old, f,
+				filepath.Join(fromSlot.Path, filepath.FromSlash(f.Name)), filepath.Join(toSlot.Path, filepath.FromSlash(f.Name)),
+				path.Join(from, displayName(f.Name)), path.Join(to, displayName(f.Name)))
+			if err != nil {
+				return res, err
+			}
+			change.File = displayName(f.Name)
+			res.Files = append(res.Files, change)
+		}
+	}
+	return res, nil
+}
+
+// fileDiffKind classifies an added or removed file
+func fileDiffKind(f ArchiveFile, name string, p string) string {
+	if f.Type != "file" {
+		return f.Type
+	}
+	content, err := os.ReadFile(p)
+	if err != nil || isSecretFile(name, content) {
+		return "secret"
+	}
+	return lo.Ternary(isTextFile(content), "text", "binary")
+}
+
+// diffFile describes how a file changed, labelling a text diff with the given names. Secrets are never
+// shown, private keys not even as checksums
+func diffFile(old ArchiveFile, f ArchiveFile, oldPath string, newPath string, oldName string, newName string) (DiffFileResult, error) {
+	change := DiffFileResult{Change: "changed"}
+	switch {
+	case old.Type != f.Type:
+		change.Kind = "type"
+		change.Detail = fmt.Sprintf("%s -> %s", old.Type, f.Type)
+		return change, nil
+	case f.Type == "symlink" && old.Target != f.Target:
+		change.Kind = "symlink"
+		change.Detail = fmt.Sprintf("%s -> %s", old.Target, f.Target)
+		return change, nil
+	case f.Type != "file" || old.Sha256 == f.Sha256:
+		change.Kind = "mode"
+		change.Detail = fmt.Sprintf("mode %04o -> %04o", old.Mode, f.Mode)
+		return change, nil
+	}
+
+	oldContent, err := os.ReadFile(oldPath)
+	if err != nil {
+		return change, err
+	}
+	newContent, err := os.ReadFile(newPath)
+	if err != nil {
+		return change, err
+	}
+	if old.Mode != f.Mode {
+		change.Detail = fmt.Sprintf("mode %04o -> %04o, ", old.Mode, f.Mode)
+	}
+
+	switch {
+	case isSecretFile(newName, oldContent) || isSecretFile(newName, newContent):
+		change.Kind = "secret"
+		change.Detail += "contents differ, masked"
+	case isTextFile(oldContent) && isTextFile(newContent) && len(oldContent) <= maxTextDiffSize && len(newContent) <= maxTextDiffSize:
+		change.Kind = "text"
+		change.Diff = unifiedDiff(oldName, newName, maskSecretValues(string(oldContent)), maskSecretValues(string(newContent)), diffContext)
+		if change.Diff == "" {
+			change.Detail += "secret values differ, masked"
+		}
+	default:
+		change.Kind = "binary"
+		change.Detail += fmt.Sprintf("%s -> %s, sha256 %s -> %s", formatSize(len(oldContent)), formatSize(len(newContent)), shortRev(old.Sha256), shortRev(f.Sha256))
+	}
+	change.Detail = strings.TrimSuffix(change.Detail, ", ")
+	return change, nil
+}
+
+func isTextFile(content []byte) bool {
+	return utf8.Valid(content) && !bytes.ContainsRune(content, 0)
+}
+
+// secretFileNames are files that only hold secrets, matched case-insensitively against the base name
+var secretFileNames = regexp.MustCompile(`(?i)^(id_[^.]+|.*\.(pem|key|p12|pfx|jks|keystore|kdbx)|credentials|\.netrc|\.pgpass|\.git-credentials|\.htpasswd)$`)
+
+// secretValue matches config lines setting a secret, e.g. "token: abc" or "aws_secret_access_key = abc",
+// keeping the key and masking the value
+var secretValue = regexp.MustCompile(`(?im)^(\s*-?\s*"?[\w.-]*(secret|token|password|passwd|key-data|private[_-]?key|access[_-]?key)[\w.-]*"?\s*[:=]\s*)\S.*$`)
+
+// isSecretFile checks if a file looks like it only holds secrets, like private keys, so it is masked as a whole
+func isSecretFile(name string, content []byte) bool {
+	return secretFileNames.MatchString(path.Base(name)) || bytes.Contains(content, []byte("PRIVATE KEY-----"))
+}
+
+// maskSecretValues masks the values of secret-looking settings in a text file
+func maskSecretValues(text string) string {
+	return secretValue.ReplaceAllString(text, "${1}***")
+}
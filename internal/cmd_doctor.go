@@ -5,9 +5,12 @@ import (
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
+	"maps"
+	"slices"
+	"strings"
 )
 
-func DoctorCmd(gc GlobalConfig) *cobra.Command {
+func DoctorCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
 
 	var params struct {
 		// TODO: Implement repair mode in the future
@@ -20,6 +23,20 @@ func DoctorCmd(gc GlobalConfig) *cobra.Command {
 		Params:      &params,
 		ParamEnrich: paramEnricherDefault,
 		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc, err := lazyGc.Load()
+			if err != nil {
+				infof(" * ERROR * Failed to load configuration: %v\n", err)
+				// the paths are still checked, as far as they can be read from the broken config
+				if paths, err := readGlobalConfPaths(); err == nil {
+					for _, path := range paths {
+						infof("Checking path: %s\n", path.SrcPath)
+						if problem := path.Problem(); problem != "" {
+							infof(" * WARN * %s\n", problem)
+						}
+					}
+				}
+				ExitWithMsg(ExitConfig, fmt.Sprintf("Fix or remove %s, or run 'profs reset' to start over", GlobalConfigPath()))
+			}
 
 			allProfileNames := gc.DetectedProfileNames()
 			activeProfileNames := gc.ActiveProfileNames()
@@ -33,53 +50,117 @@ func DoctorCmd(gc GlobalConfig) *cobra.Command {
 				ExitWithMsg(1, "No active profiles detected, nothing to do. Activate at least one profile first")
 			}
 
-			if len(activeProfileNames) != 1 {
+			activeMix, mixActive := gc.ActiveMixName()
+			var mixEntries []MixEntry
+			if mixActive {
+				mixEntries, _ = gc.MixEntries(activeMix)
+				infof("Active mix: %s\n", activeMix)
+			}
+
+			if len(activeProfileNames) != 1 && !gc.IsIntendedMixedState() && !mixActive {
 				// TODO: Implement fix/repair operation
 				ExitWithMsg(1, fmt.Sprintf("Multiple active profiles detected (%d), expected only one active profile. Please deactivate all but one profile", len(activeProfileNames)))
 			}
 
-			globallyActiveProfile := activeProfileNames[0]
+			// paths switched separately by a partial 'set' are expected to differ from the rest
+			globallyActiveProfile := lo.FirstOrEmpty(gc.BaseProfileNames())
+
+			result := DoctorResult{resultHeader: newResultHeader("doctor"), Findings: []Finding{}}
+			report := func(severity string, path Path, msg string) {
+				infof(" * %s * %s\n", strings.ToUpper(severity), msg)
+				result.Findings = append(result.Findings, Finding{Severity: severity, Path: path.SrcPath, Message: msg})
+				if severity == "warn" {
+					result.Warnings++
+				}
+			}
 
-			numWarnings := 0
 			for _, path := range gc.Paths {
 
-				fmt.Printf("Checking path: %s\n", path.SrcPath)
+				infof("Checking path: %s\n", path.SrcPath)
 
-				if !isSymlink(path.SrcPath) {
+				switch path.Status {
+				case StatusErrorSrcNotSymlink:
 					// TODO: Implement fix/repair operation
-					fmt.Printf(" * WARN * Source path %s is not a symlink, expected a symlink to a profile directory.\n", path.SrcPath)
-					numWarnings++
+					report("warn", path, fmt.Sprintf("Source path %s is not a symlink, expected a symlink to a profile directory.", path.SrcPath))
+					continue
+				case StatusErrorSrcNotFound, StatusErrorPermissionDenied, StatusErrorSymlinkLoop, StatusErrorStorageNotFound, StatusErrorIO:
+					report("warn", path, path.Problem())
 					continue
+				case StatusErrorWrongSlotType:
+					report("warn", path, path.Problem())
+				}
+
+				expectedProfile := globallyActiveProfile
+				if path.OverrideProfile != "" {
+					expectedProfile = path.OverrideProfile
+				}
+				if mixActive {
+					// paths outside the active mix may be on any profile
+					expectedProfile = ""
+					if e, inMix := lo.Find(mixEntries, func(e MixEntry) bool { return e.Path.SrcPath == path.SrcPath }); inMix {
+						expectedProfile = e.Profile
+					}
+				}
+				if gc.IsSparse(expectedProfile, path) {
+					// the path stays on whatever profile it was on
+					expectedProfile = ""
 				}
 
 				dirActiveProfile, err := path.ActiveProfile()
-				if err != nil {
+				if path.Status == StatusEmpty {
+					report("info", path, fmt.Sprintf("Path %s points to an empty placeholder (missing slot policy: %s)", path.SrcPath, path.MissingSlot))
+				} else if err != nil {
 					// TODO: Implement fix/repair operation
-					fmt.Printf(" * WARN * Error getting active profile for path %s: %v\n", path.SrcPath, err)
-					numWarnings++
-				} else if dirActiveProfile != globallyActiveProfile {
+					report("warn", path, fmt.Sprintf("Error getting active profile for path %s: %v", path.SrcPath, err))
+				} else if expectedProfile != "" && dirActiveProfile != expectedProfile {
 					// TODO: Implement fix/repair operation
-					fmt.Printf(" * WARN * Active profile for path %s is '%s', expected '%s'.\n", path.SrcPath, dirActiveProfile, globallyActiveProfile)
-					numWarnings++
+					report("warn", path, fmt.Sprintf("Active profile for path %s is '%s', expected '%s'.", path.SrcPath, dirActiveProfile, expectedProfile))
 				}
 
 				profilesForPath := lo.Map(path.DetectedProfs, func(item DetectedProfile, _ int) string {
 					return item.Name
 				})
+				for _, profile := range profilesForPath {
+					if gc.IsSparse(profile, path) {
+						report("warn", path, fmt.Sprintf("Profile '%s' has a slot for path %s, but is declared as not managing it", profile, path.SrcPath))
+					}
+				}
 				missingProfiles, _ := lo.Difference(allProfileNames, profilesForPath)
-				if len(missingProfiles) > 0 {
+				sparseProfiles := lo.Filter(missingProfiles, func(profile string, _ int) bool { return gc.IsSparse(profile, path) })
+				if len(sparseProfiles) > 0 {
+					report("info", path, fmt.Sprintf("Profiles %v are declared as not managing path %s", sparseProfiles, path.SrcPath))
+					missingProfiles, _ = lo.Difference(missingProfiles, sparseProfiles)
+				}
+				policy, _, _ := parseMissingSlotPolicy(path.MissingSlot)
+				if len(missingProfiles) > 0 && (policy == MissingSlotEmpty || policy == MissingSlotFallback) {
+					report("info", path, fmt.Sprintf("Profiles %v have no slot for path %s, handled by missing slot policy: %s", missingProfiles, path.SrcPath, path.MissingSlot))
+				} else if len(missingProfiles) > 0 {
 					// TODO: Implement fix/repair operation
 					for _, missingProfile := range missingProfiles {
-						fmt.Printf(" * WARN * Profile '%s' is missing for path %s\n", missingProfile, path.SrcPath)
-						numWarnings++
+						report("warn", path, fmt.Sprintf("Profile '%s' is missing for path %s", missingProfile, path.SrcPath))
+					}
+				}
+			}
+
+			// declarations must refer to existing profiles and managed paths
+			for _, profile := range slices.Sorted(maps.Keys(gc.Sparse)) {
+				if !lo.Contains(allProfileNames, profile) {
+					report("warn", Path{}, fmt.Sprintf("Config declares paths not managed by profile '%s', which does not exist", profile))
+				}
+				for _, key := range gc.Sparse[profile] {
+					if !lo.ContainsBy(gc.Paths, func(p Path) bool { return pathMatchesKey(key, p.SrcPath) }) {
+						report("warn", Path{}, fmt.Sprintf("Config declares that profile '%s' does not manage path '%s', which is not managed by profs", profile, key))
 					}
 				}
 			}
 
-			if numWarnings == 0 {
-				fmt.Println("No inconsistencies found, everything seems to be in order.")
+			printResult(result, nil)
+			if result.Warnings == 0 {
+				infof("No inconsistencies found, everything seems to be in order.\n")
+			} else if machineOutput() {
+				exitWithCode(ExitFindings, fmt.Sprintf("Found %d inconsistencies", result.Warnings))
 			} else {
-				ExitWithMsg(1, fmt.Sprintf("Found %d inconsistencies, please review the warnings above.", numWarnings))
+				ExitWithMsg(ExitFindings, fmt.Sprintf("Found %d inconsistencies, please review the warnings above.", result.Warnings))
 			}
 		},
 	}.ToCobra()
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"filippo.io/age"
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func ExportCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Profile    string `positional:"true" descr:"Profile to export"`
+		File       string `short:"f" descr:"Archive to write, defaults to <profile>.tar.zst, or <profile>.tar.zst.age if encrypted" optional:"true"`
+		Encrypt    bool   `descr:"Encrypt the archive with age, to the recipients in the config, or with a passphrase if there are none" default:"false"`
+		Passphrase bool   `descr:"Encrypt the archive with a passphrase, even if recipients are configured" default:"false"`
+		Sign       string `descr:"Sign the archive with this OpenSSH ed25519 private key, e.g. ~/.ssh/id_ed25519" optional:"true"`
+	}
+
+	return boa.Cmd{
+		Use:           "export",
+		Short:         "Export a profile to a checksummed archive, e.g. to move it to another machine",
+		Params:        &params,
+		ParamEnrich:   paramEnricherDefault,
+		ValidArgsFunc: lazyGc.completeProfiles,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			if !lo.Contains(gc.DetectedProfileNames(), params.Profile) {
+				ExitWithMsg(1, fmt.Sprintf("Profile '%s' not found", params.Profile))
+			}
+			exitIfSealed(gc, params.Profile)
+
+			var paths, slots []string
+			for _, p := range gc.Paths {
+				if slot, found := lo.Find(p.DetectedProfs, func(prof DetectedProfile) bool { return prof.Name == params.Profile }); found {
+					paths = append(paths, p.SrcPath)
+					slots = append(slots, slot.Path)
+				}
+			}
+
+			manifest, err := newArchiveManifest(params.Profile, paths, slots, time.Now())
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to export profile '%s': %v", params.Profile, err))
+			}
+
+			var signingKey ed25519.PrivateKey
+			if params.Sign != "" {
+				signingKey, err = loadSigningKey(params.Sign)
+				if err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to load signing key '%s': %v", params.Sign, err))
+				}
+			}
+
+			encrypt := params.Encrypt || params.Passphrase
+			var recipients []age.Recipient
+			if encrypt {
+				recipients, err = newKeyring(gc.Encryption, params.Passphrase).Recipients()
+				if err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to encrypt archive: %v", err))
+				}
+			}
+
+			file := lo.CoalesceOrEmpty(params.File, params.Profile+".tar.zst"+lo.Ternary(encrypt, ".age", ""))
+			writeArchiveFileOrExit(file, func(f *os.File) error {
+				if !encrypt {
+					return writeArchive(f, manifest, slots, signingKey)
+				}
+				encrypted, err := age.Encrypt(f, recipients...)
+				if err != nil {
+					return err
+				}
+				if err := writeArchive(encrypted, manifest, slots, signingKey); err != nil {
+					return err
+				}
+				return encrypted.Close()
+			})
+
+			infof("Exported profile '%s' (%d paths) to %s\n", params.Profile, len(paths), file)
+			printResult(ExportResult{
+				resultHeader: newResultHeader("export"),
+				Profile:      params.Profile,
+				File:         file,
+				Paths:        orEmpty(lo.Map(manifest.Paths, func(p ArchivePath, _ int) string { return p.Path })),
+				Encrypted:    encrypt,
+				Signed:       signingKey != nil,
+			}, nil)
+		},
+	}.ToCobra()
+}
+
+// writeArchiveFileOrExit creates a new archive file, only readable by the user as it contains secrets,
+// and removes it again if writing fails
+func writeArchiveFileOrExit(file string, write func(f *os.File) error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		ExitWithMsg(1, fmt.Sprintf("Archive '%s' already exists", file))
+	}
+	if err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to create archive '%s': %v", file, err))
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file)
+		ExitWithMsg(1, fmt.Sprintf("Failed to write archive '%s': %v", filepath.Clean(file), err))
+	}
+}
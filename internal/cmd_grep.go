@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func GrepCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Pattern    string   `positional:"true" descr:"Regular expression to search for, e.g. 'current-context' or '1234567890'"`
+		Profile    []string `descr:"Only search the slots of these profiles (repeatable)" optional:"true"`
+		Path       []string `descr:"Only search the slots of these paths (repeatable)" optional:"true"`
+		IgnoreCase bool     `short:"i" descr:"Match case-insensitively" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "grep",
+		Short:       "Search the files of all profiles, including inactive ones, for a pattern",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			pattern := lo.Ternary(params.IgnoreCase, "(?i)", "") + params.Pattern
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				ExitWithMsg(ExitUsage, fmt.Sprintf("Invalid pattern '%s': %v", params.Pattern, err))
+			}
+
+			var matches []GrepMatchResult
+			var skipped []GrepSkippedResult
+			walkProfileFiles(gc, params.Profile, params.Path, func(p Path, slot DetectedProfile, rel string, d fs.DirEntry) {
+				if !d.Type().IsRegular() {
+					return
+				}
+				absFile := filepath.Join(slot.Path, filepath.FromSlash(rel))
+				content, err := os.ReadFile(absFile)
+				if err != nil {
+					infof("WARNING: Failed to read '%s': %v\n", absFile, err)
+					return
+				}
+				if !isTextFile(content) {
+					return
+				}
+				// secrets are never searched, so they can't be found by guessing either, but are reported as skipped
+				if isSecretFile(slotFileName(p, rel), content) {
+					skipped = append(skipped, GrepSkippedResult{Profile: slot.Name, Path: managedFilePath(p, rel)})
+					return
+				}
+				for i, line := range splitLines(maskSecretValues(string(content))) {
+					if re.MatchString(line) {
+						matches = append(matches, GrepMatchResult{Profile: slot.Name, Path: managedFilePath(p, rel), Line: i + 1, Text: line})
+					}
+				}
+			})
+
+			printResult(GrepResult{
+				resultHeader: newResultHeader("grep"),
+				Pattern:      params.Pattern,
+				Matches:      orEmpty(matches),
+				Skipped:      orEmpty(skipped),
+			}, func() {
+				for _, m := range matches {
+					fmt.Printf("%s:%s:%d:%s\n", m.Profile, m.Path, m.Line, m.Text)
+				}
+			})
+			if len(skipped) > 0 {
+				infof("Skipped %d file(s) that only hold secrets, which are never searched:\n", len(skipped))
+				for _, f := range skipped {
+					infof("  %s: %s\n", f.Profile, f.Path)
+				}
+			}
+			if len(matches) == 0 {
+				msg := fmt.Sprintf("No matches for '%s'", params.Pattern)
+				if len(skipped) > 0 {
+					msg += fmt.Sprintf(", skipped %d file(s) holding secrets", len(skipped))
+				}
+				if machineOutput() {
+					exitWithCode(1, msg)
+				}
+				ExitWithMsg(1, msg)
+			}
+		},
+	}.ToCobra()
+}
+
+func WhichCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		File    string   `positional:"true" descr:"File to look for, by name (e.g. id_ed25519_acme), relative path within a slot, or path within a managed path"`
+		Profile []string `descr:"Only look in the slots of these profiles (repeatable)" optional:"true"`
+		Path    []string `descr:"Only look in the slots of these paths (repeatable)" optional:"true"`
+	}
+
+	return boa.Cmd{
+		Use:         "which",
+		Short:       "List the profiles containing a file",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+
+			// a path within a managed path, like ~/.ssh/id_ed25519_acme, is looked for in the slots of that path
+			wanted := filepath.ToSlash(params.File)
+			paths := params.Path
+			if absFile, err := toAbsPath(params.File); err == nil {
+				if p, file, found := managedPathOf(gc, absFile); found {
+					wanted = file
+					paths = append(paths, p.SrcPath)
+				}
+			}
+
+			var matches []WhichMatchResult
+			walkProfileFiles(gc, params.Profile, paths, func(p Path, slot DetectedProfile, rel string, d fs.DirEntry) {
+				name := slotFileName(p, rel)
+				if rel == wanted || name == wanted || path.Base(name) == wanted {
+					matches = append(matches, WhichMatchResult{Profile: slot.Name, Path: managedFilePath(p, rel)})
+				}
+			})
+
+			printResult(WhichResult{
+				resultHeader: newResultHeader("which"),
+				File:         params.File,
+				Matches:      orEmpty(matches),
+			}, func() {
+				for _, m := range matches {
+					fmt.Printf("%s: %s\n", m.Profile, m.Path)
+				}
+			})
+			if len(matches) == 0 {
+				msg := fmt.Sprintf("No profile contains '%s'", params.File)
+				if machineOutput() {
+					exitWithCode(1, msg)
+				}
+				ExitWithMsg(1, msg)
+			}
+		},
+	}.ToCobra()
+}
+
+// walkProfileFiles calls fn for every file, directory and symlink in the slots of the given profiles and paths,
+// or all of them, with its path relative to the slot, "." for the slot itself. Locked slots are skipped with a warning
+func walkProfileFiles(gc GlobalConfig, profiles []string, paths []string, fn func(p Path, slot DetectedProfile, rel string, d fs.DirEntry)) {
+	for _, profile := range profiles {
+		if !lo.Contains(gc.DetectedProfileNames(), profile) {
+			ExitWithMsg(1, fmt.Sprintf("Profile '%s' not found", profile))
+		}
+	}
+	selected := gc.Paths
+	if len(paths) > 0 {
+		selected = selectPaths(gc, paths, nil)
+	}
+
+	for _, p := range selected {
+		for _, slot := range p.DetectedProfs {
+			if len(profiles) > 0 && !lo.Contains(profiles, slot.Name) {
+				continue
+			}
+			if slot.Sealed {
+				infof("WARNING: Skipping the slot of profile %s for %s, it is locked\n", slot.Name, p.SrcPath)
+				continue
+			}
+			err := filepath.WalkDir(slot.Path, func(f string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				rel, err := filepath.Rel(slot.Path, f)
+				if err != nil {
+					return err
+				}
+				fn(p, slot, filepath.ToSlash(rel), d)
+				return nil
+			})
+			if err != nil {
+				infof("WARNING: Failed to read the slot of profile %s for %s: %v\n", slot.Name, p.SrcPath, err)
+			}
+		}
+	}
+}
+
+// slotFileName is the name of a file within a slot, or the name of the managed path for a file slot
+func slotFileName(p Path, rel string) string {
+	return lo.Ternary(rel == ".", filepath.Base(p.SrcPath), rel)
+}
+
+// managedFilePath is where a file of a slot appears when the slot is active, as written in the config
+func managedFilePath(p Path, rel string) string {
+	return toConfigPath(filepath.Join(p.SrcPath, filepath.FromSlash(rel)))
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/spf13/cobra"
+)
+
+// guardHookMarker identifies git hooks installed by 'profs guard --install'
+const guardHookMarker = "# installed by profs guard"
+
+func WhichProfileCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Dir string `positional:"true" optional:"true" descr:"Directory to select a profile for (defaults to the current directory)"`
+	}
+
+	return boa.Cmd{
+		Use:         "which-profile",
+		Short:       "Print the profile selected for a directory by marker files or git remote rules",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			selection, found := selectProfileForDirOrExit(gc, params.Dir)
+			if !found {
+				ExitWithMsg(1, "No profile selected for this directory")
+			}
+			fmt.Println(selection.Profile)
+			_, _ = fmt.Fprintf(os.Stderr, "(%s)\n", selection.Source)
+		},
+	}.ToCobra()
+}
+
+func GuardCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Dir     string `positional:"true" optional:"true" descr:"Directory of the git repository (defaults to the current directory)"`
+		Install bool   `descr:"Install the guard as pre-commit and pre-push hooks in the git repository" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:   "guard",
+		Short: "Fail if the active profile doesn't match the profile selected for a git repository",
+		Long: "Fail if the active profile doesn't match the profile selected for a git repository,\n" +
+			"by " + ProfileMarkerFile + " files or git remote rules. Meant to be used as a git\n" +
+			"pre-commit/pre-push hook, install it in the current repository with 'profs guard --install'.",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+
+			if params.Install {
+				installGuardHooks(params.Dir)
+				return
+			}
+
+			selection, found := selectProfileForDirOrExit(gc, params.Dir)
+			if !found || gc.IsActive(selection.Profile) {
+				return
+			}
+
+			ExitWithMsg(1, fmt.Sprintf(
+				"profs guard: this repository requires profile '%s', but the active profile is '%s'\n"+
+					"  (%s)\n"+
+					"  Run 'profs set %s' to switch",
+				selection.Profile,
+				strings.Join(gc.ActiveProfileNames(), ", "),
+				selection.Source,
+				selection.Profile,
+			))
+		},
+	}.ToCobra()
+}
+
+func selectProfileForDirOrExit(gc GlobalConfig, dir string) (ProfileSelection, bool) {
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			ExitWithMsg(1, fmt.Sprintf("Failed to get current directory: %v", err))
+		}
+		dir = wd
+	}
+
+	selection, found, err := gc.SelectProfileForDir(dir)
+	if err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to select profile for '%s': %v", dir, err))
+	}
+	return selection, found
+}
+
+// installGuardHooks writes pre-commit and pre-push hooks running 'profs guard',
+// refusing to overwrite hooks not installed by profs
+func installGuardHooks(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	root, _, found, err := findGitDir(dir)
+	if err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to find git repository for '%s': %v", dir, err))
+	}
+	if !found {
+		ExitWithMsg(1, fmt.Sprintf("'%s' is not inside a git repository", dir))
+	}
+
+	// worktrees and core.hooksPath move the hooks out of the git directory
+	hooksDir, err := gitHooksDir(root)
+	if err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to find the hooks directory of '%s': %v", root, err))
+	}
+	if hooksPath, err := runGit(root, "config", "core.hooksPath"); err == nil && hooksPath != "" {
+		fmt.Printf("NOTE: core.hooksPath is set to %s, the hooks apply to every repository using it\n", hooksPath)
+	}
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to create hooks directory '%s': %v", hooksDir, err))
+	}
+
+	hooks := []string{"pre-commit", "pre-push"}
+	for _, hook := range hooks {
+		hookPath := filepath.Join(hooksDir, hook)
+		if existing, err := os.ReadFile(hookPath); err == nil && !strings.Contains(string(existing), guardHookMarker) {
+			ExitWithMsg(1, fmt.Sprintf("Hook '%s' already exists and was not installed by profs, add 'profs guard' to it manually", hookPath))
+		}
+	}
+
+	script := "#!/bin/sh\n" + guardHookMarker + "\nexec profs guard\n"
+	for _, hook := range hooks {
+		hookPath := filepath.Join(hooksDir, hook)
+		if err := os.WriteFile(hookPath, []byte(script), 0755); err != nil {
+			ExitWithMsg(1, fmt.Sprintf("Failed to write hook '%s': %v", hookPath, err))
+		}
+		fmt.Printf("Installed %s\n", hookPath)
+	}
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func HistoryCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Path    string `positional:"true" descr:"Managed path to show the history of"`
+		Profile string `descr:"Only show changes to the slot of this profile" optional:"true"`
+	}
+
+	return boa.Cmd{
+		Use:         "history",
+		Short:       "Show the recorded versions of a managed path, with versioning enabled in the config",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			p := selectPaths(gc, []string{params.Path}, nil)[0]
+			exitIfNotVersioned(gc, p)
+
+			entries, err := storageHistory(p.StorageDir, params.Profile)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to read the history of path %s: %v", p.SrcPath, err))
+			}
+
+			printResult(HistoryResult{
+				resultHeader: newResultHeader("history"),
+				Path:         p.SrcPath,
+				Profile:      params.Profile,
+				Entries: orEmpty(lo.Map(entries, func(e HistoryEntry, _ int) HistoryEntryResult {
+					return HistoryEntryResult{Rev: e.Rev, Date: e.Date, Message: e.Message}
+				})),
+			}, func() {
+				if len(entries) == 0 {
+					fmt.Printf("No history recorded for path %s yet\n", p.SrcPath)
+				}
+				for _, e := range entries {
+					fmt.Printf("%s %s %s\n", shortRev(e.Rev), e.Date.Local().Format(time.DateTime), e.Message)
+				}
+			})
+		},
+	}.ToCobra()
+}
+
+func RestoreCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Path    string `positional:"true" descr:"Managed path to restore a slot of"`
+		Profile string `descr:"Profile whose slot to restore"`
+		At      string `descr:"Revision to restore the slot to, as shown by 'profs history'"`
+		DryRun  bool   `descr:"Print the planned filesystem operations without performing them" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "restore",
+		Short:       "Restore the slot of a profile to a version recorded by 'profs history'",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			p := selectPaths(gc, []string{params.Path}, nil)[0]
+			exitIfNotVersioned(gc, p)
+			exitIfSealed(gc, params.Profile)
+
+			rev, err := resolveRev(p.StorageDir, params.At)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to restore path %s: %v", p.SrcPath, err))
+			}
+			if _, err := runGit(p.StorageDir, "cat-file", "-e", rev+":"+params.Profile); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Profile '%s' has no slot for path %s at revision %s", params.Profile, p.SrcPath, shortRev(rev)))
+			}
+
+			// the current content is committed first, so that the restore can be undone the same way
+			fsys := newFsOps(params.DryRun)
+			slot := filepath.Join(p.StorageDir, params.Profile)
+			if err := commitStorage(fsys, p.StorageDir, fmt.Sprintf("Before restoring %s to %s", params.Profile, shortRev(rev))); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to record the history of path %s: %v", p.SrcPath, err))
+			}
+			if fileOrDirExistsOrExit(slot) {
+				if err := fsys.RemoveAll(slot); err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to remove slot %s: %v", slot, err))
+				}
+			}
+			if err := fsys.Git(p.StorageDir, "checkout", rev, "--", params.Profile); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to restore slot %s, its previous content is in revision HEAD: %v", slot, err))
+			}
+			if err := restoreSlotModes(fsys, p.StorageDir, rev, params.Profile); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to restore the permissions of slot %s: %v", slot, err))
+			}
+			if err := commitStorage(fsys, p.StorageDir, fmt.Sprintf("Restore %s to %s", params.Profile, shortRev(rev))); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to record the history of path %s: %v", p.SrcPath, err))
+			}
+			fsys.RefreshState()
+
+			infof("Restored profile %s for path %s to revision %s\n", params.Profile, p.SrcPath, shortRev(rev))
+			printResult(RestoreResult{
+				resultHeader: newResultHeader("restore"),
+				Path:         p.SrcPath,
+				Profile:      params.Profile,
+				Rev:          rev,
+			}, nil)
+		},
+	}.ToCobra()
+}
+
+// exitIfNotVersioned refuses to show or restore history of a storage directory that isn't a git repository
+func exitIfNotVersioned(gc GlobalConfig, p Path) {
+	if isVersioned(p.StorageDir) {
+		return
+	}
+	if !gc.Versioning {
+		ExitWithMsg(1, fmt.Sprintf("Path %s has no history, enable versioning in %s first", p.SrcPath, GlobalConfigPath()))
+	}
+	ExitWithMsg(1, fmt.Sprintf("Path %s has no history yet, it is recorded when switching profiles", p.SrcPath))
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/spf13/cobra"
+)
+
+const bashHook = `# profs: switch profile automatically based on .profs-profile files
+_profs_hook() {
+  if [[ "$PWD" != "$_PROFS_LAST_PWD" ]]; then
+    _PROFS_LAST_PWD="$PWD"
+    command profs auto > /dev/null
+  fi
+}
+if [[ ";${PROMPT_COMMAND:-};" != *";_profs_hook;"* ]]; then
+  PROMPT_COMMAND="_profs_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+`
+
+const zshHook = `# profs: switch profile automatically based on .profs-profile files
+_profs_hook() {
+  command profs auto > /dev/null
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook chpwd _profs_hook
+_profs_hook
+`
+
+const fishHook = `# profs: switch profile automatically based on .profs-profile files
+function __profs_hook --on-variable PWD
+    command profs auto > /dev/null
+end
+__profs_hook
+`
+
+func HookCmd() *cobra.Command {
+
+	var params struct {
+		Shell string `positional:"true" descr:"Shell to print the hook for" alts:"bash,zsh,fish" strict:"true"`
+	}
+
+	return boa.Cmd{
+		Use:   "hook",
+		Short: "Print a shell hook that switches profile based on " + ProfileMarkerFile + " files",
+		Long: "Print a shell hook that switches profile based on " + ProfileMarkerFile + " files.\n" +
+			"When changing directory, the nearest " + ProfileMarkerFile + " file in the directory or its parents\n" +
+			"is read, and profs switches to the profile (or mix) it names, if not already active.\n" +
+			"NOTE: Profiles are symlinks, so the switch applies to all shells, not just the current one.\n\n" +
+			"Examples:\n" +
+			"  eval \"$(profs hook bash)\"   # in ~/.bashrc\n" +
+			"  eval \"$(profs hook zsh)\"    # in ~/.zshrc\n" +
+			"  profs hook fish | source    # in ~/.config/fish/config.fish",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			switch params.Shell {
+			case "bash":
+				fmt.Print(bashHook)
+			case "zsh":
+				fmt.Print(zshHook)
+			case "fish":
+				fmt.Print(fishHook)
+			}
+		},
+	}.ToCobra()
+}
+
+func AutoCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Dir string `positional:"true" optional:"true" descr:"Directory to select a profile for (defaults to the current directory)"`
+	}
+
+	return boa.Cmd{
+		Use:         "auto",
+		Short:       "Switch to the profile selected for a directory, if not already active",
+		Long:        "Switch to the profile selected for a directory by the nearest " + ProfileMarkerFile + " file,\nor by the git remote rules in the config, if not already active.\nThis is what the shell hook from 'profs hook' runs on every directory change.",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+
+			dir := params.Dir
+			if dir == "" {
+				wd, err := os.Getwd()
+				if err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to get current directory: %v", err))
+				}
+				dir = wd
+			}
+
+			selection, found, err := gc.SelectProfileForDir(dir)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to select profile for '%s': %v", dir, err))
+			}
+			if !found || gc.IsActive(selection.Profile) {
+				return
+			}
+
+			if !gc.IsKnownProfileOrMix(selection.Profile) {
+				_, _ = fmt.Fprintf(os.Stderr, "profs: unknown profile '%s' selected by %s\n", selection.Profile, selection.Source)
+				return
+			}
+
+			_, _ = fmt.Fprintf(os.Stderr, "profs: switching to '%s' (%s)\n", selection.Profile, selection.Source)
+			switchProfile(osOps{}, gc, selection.Profile, nil, nil)
+		},
+	}.ToCobra()
+}
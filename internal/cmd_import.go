@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func ImportCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		File          string   `positional:"true" descr:"Archive created by 'profs export', encrypted ones are decrypted transparently"`
+		As            string   `descr:"Import the profile under another name" optional:"true"`
+		RequireSigner []string `descr:"Only accept archives signed by this ed25519 public key or .pub file (repeatable), instead of the trusted signers in the config" optional:"true"`
+		DryRun        bool     `descr:"Print the planned filesystem operations without performing them" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "import",
+		Short:       "Import a profile from an archive created by 'profs export'",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+
+			// Extract and verify the whole archive before changing anything
+			tmpDir, err := os.MkdirTemp("", "profs-import-")
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to create temporary directory: %v", err))
+			}
+			fail := func(msg string) {
+				_ = os.RemoveAll(tmpDir)
+				ExitWithMsg(1, msg)
+			}
+			defer func() { _ = os.RemoveAll(tmpDir) }()
+
+			f, err := os.Open(params.File)
+			if err != nil {
+				fail(fmt.Sprintf("Failed to open archive '%s': %v", params.File, err))
+			}
+			archive, _, err := decryptIfEncrypted(f, newKeyring(gc.Encryption, false))
+			if err != nil {
+				_ = f.Close()
+				fail(fmt.Sprintf("Failed to import '%s': %v", params.File, err))
+			}
+			var signedBy string
+			trustedSigners := lo.Ternary(len(params.RequireSigner) > 0, params.RequireSigner, gc.TrustedSigners)
+			manifest, err := readArchive(archive, tmpDir, verifySigners(trustedSigners, &signedBy))
+			_ = f.Close()
+			if err != nil {
+				fail(fmt.Sprintf("Failed to import '%s': %v", params.File, err))
+			}
+
+			// the archive is not trusted to choose where it is extracted to
+			profile := lo.CoalesceOrEmpty(params.As, manifest.Profile)
+			if err := validateProfileName(profile); err != nil {
+				fail(fmt.Sprintf("Cannot import '%s': %v", params.File, err))
+			}
+			if err := validateArchivePaths(gc, manifest); err != nil {
+				fail(fmt.Sprintf("Cannot import '%s': %v", params.File, err))
+			}
+			if lo.ContainsBy(gc.DetectedProfileNames(), func(item string) bool {
+				return strings.ToLower(item) == strings.ToLower(profile)
+			}) {
+				fail(fmt.Sprintf("Profile '%s' already exists, import it under another name with --as", profile))
+			}
+
+			// Paths not managed yet are added like 'profs add' does, to the current profile if there is one
+			baseProfiles := gc.BaseProfileNames()
+			managedPathOf := func(ap ArchivePath) (Path, bool) {
+				return lo.Find(gc.Paths, func(p Path) bool { return pathsAreEqual(p.SrcPath, expandHomePath(ap.Path)) })
+			}
+			for _, ap := range manifest.Paths {
+				if p, managed := managedPathOf(ap); managed {
+					if target := filepath.Join(p.StorageDir, profile); fileOrDirExistsOrExit(target) {
+						fail(fmt.Sprintf("Cannot import profile '%s', '%s' already exists", profile, target))
+					}
+				} else if len(baseProfiles) != 1 && fileOrDirExistsOrExit(expandHomePath(ap.Path)) {
+					fail(fmt.Sprintf("Path '%s' is not managed by profs and already exists, add it with 'profs add %s --profile <name>' first", ap.Path, ap.Path))
+				}
+			}
+
+			fsys := newFsOps(params.DryRun)
+			rawConfig := loadGlobalConfRawOrExit()
+			var added []string
+			// slots are moved back into the extracted archive on rollback, so it is removed last
+			undo := rollback{}
+			undo.add(func() error { return os.RemoveAll(tmpDir) })
+			failAfterRollback := func(msg string) { exitAfterRollback(&undo, msg) }
+			for i, ap := range manifest.Paths {
+				slot := filepath.Join(tmpDir, filepath.FromSlash(archiveSlotName(i)))
+				absPath := expandHomePath(ap.Path)
+
+				if p, managed := managedPathOf(ap); managed {
+					target := filepath.Join(p.StorageDir, profile)
+					if err := fsys.Rename(slot, target); err != nil {
+						failAfterRollback(fmt.Sprintf("Failed to place slot '%s': %v", target, err))
+					}
+					undo.add(func() error { return fsys.Rename(target, slot) })
+					infof("Imported %s\n", target)
+					continue
+				}
+
+				if len(baseProfiles) == 1 {
+					// the path gets a slot for the current profile, of the same type as the imported one
+					if ap.slotType() == "file" && !fileOrDirExistsOrExit(absPath) {
+						if err := fsys.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+							failAfterRollback(fmt.Sprintf("Failed to create directory for '%s': %v", absPath, err))
+						}
+						if err := fsys.WriteFile(absPath, []byte{}, 0644); err != nil {
+							failAfterRollback(fmt.Sprintf("Failed to create '%s': %v", absPath, err))
+						}
+						undo.add(func() error { return fsys.Remove(absPath) })
+					}
+					_, storageDir := addPath(fsys, gc, &rawConfig, absPath, baseProfiles[0], nil, &undo)
+					target := filepath.Join(storageDir, profile)
+					if err := fsys.Rename(slot, target); err != nil {
+						failAfterRollback(fmt.Sprintf("Failed to place slot for '%s': %v", absPath, err))
+					}
+					undo.add(func() error { return fsys.Rename(target, slot) })
+				} else {
+					// without a current profile, e.g. on a new machine, the imported profile becomes the current one
+					if err := fsys.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+						failAfterRollback(fmt.Sprintf("Failed to create directory for '%s': %v", absPath, err))
+					}
+					if err := fsys.Rename(slot, absPath); err != nil {
+						failAfterRollback(fmt.Sprintf("Failed to place '%s': %v", absPath, err))
+					}
+					undo.add(func() error { return fsys.Rename(absPath, slot) })
+					addPath(fsys, gc, &rawConfig, absPath, profile, nil, &undo)
+				}
+				infof("Added path %s with profile %s\n", absPath, profile)
+				added = append(added, absPath)
+			}
+
+			// the imported profile doesn't manage local paths it has no slot for
+			for _, p := range gc.Paths {
+				if !lo.ContainsBy(manifest.Paths, func(ap ArchivePath) bool { return pathsAreEqual(p.SrcPath, expandHomePath(ap.Path)) }) {
+					rawConfig.setSparse(profile, p.SrcPath, true)
+				}
+			}
+			fsys.SaveConfig(rawConfig)
+			fsys.RefreshState()
+
+			printResult(ImportResult{
+				resultHeader: newResultHeader("import"),
+				Profile:      profile,
+				Paths:        orEmpty(lo.Map(manifest.Paths, func(ap ArchivePath, _ int) string { return ap.Path })),
+				Added:        orEmpty(added),
+				SignedBy:     signedBy,
+			}, nil)
+		},
+	}.ToCobra()
+}
+
+// validateArchivePaths checks that the paths of an archive that are not managed yet are within the home
+// directory, so an archive can't make profs take over other paths
+func validateArchivePaths(gc GlobalConfig, manifest ArchiveManifest) error {
+	homeDir := filepath.Clean(HomeDir())
+	for _, ap := range manifest.Paths {
+		absPath := filepath.Clean(expandHomePath(ap.Path))
+		if lo.ContainsBy(gc.Paths, func(p Path) bool { return pathsAreEqual(p.SrcPath, absPath) }) {
+			continue
+		}
+		if !filepath.IsAbs(absPath) || !strings.HasPrefix(absPath, homeDir+string(filepath.Separator)) {
+			return fmt.Errorf("path '%s' in the archive is not within the home directory", ap.Path)
+		}
+	}
+	return nil
+}
@@ -1,11 +1,14 @@
 package internal
 
 import (
+	"fmt"
+
 	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 )
 
-func ListProfilesCmd(cmdName string, gc GlobalConfig) *cobra.Command {
+func ListProfilesCmd(cmdName string, lazyGc *LazyGlobalConfig) *cobra.Command {
 
 	var params struct {
 	}
@@ -16,17 +19,33 @@ func ListProfilesCmd(cmdName string, gc GlobalConfig) *cobra.Command {
 		Params:      &params,
 		ParamEnrich: paramEnricherDefault,
 		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
 
 			profileNames := gc.DetectedProfileNames()
-			if len(profileNames) == 0 {
-				println("No profiles detected")
-				return
-			} else {
-				println("Detected profiles:")
-				for _, profileName := range profileNames {
-					println("  " + profileName)
-				}
+			result := ListResult{
+				resultHeader: newResultHeader("list"),
+				Profiles: lo.Map(profileNames, func(name string, _ int) ProfileResult {
+					return ProfileResult{Name: name, Active: lo.Contains(gc.ActiveProfileNames(), name), Locked: gc.IsLocked(name)}
+				}),
+				Mixes: orEmpty(gc.MixNames()),
 			}
+			result.Profiles = orEmpty(result.Profiles)
+
+			printResult(result, func() {
+				if len(profileNames) == 0 {
+					fmt.Println("No profiles detected")
+					return
+				} else {
+					fmt.Println("Detected profiles:")
+					for _, profileName := range profileNames {
+						if gc.IsLocked(profileName) {
+							fmt.Println("  " + profileName + " (locked)")
+						} else {
+							fmt.Println("  " + profileName)
+						}
+					}
+				}
+			})
 		},
 	}.ToCobra()
 }
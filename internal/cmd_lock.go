@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func LockCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Profile    []string `descr:"Only lock these profiles (repeatable), defaults to all inactive profiles" optional:"true"`
+		Passphrase bool     `descr:"Encrypt with a passphrase, even if recipients are configured" default:"false"`
+		DryRun     bool     `descr:"Print the planned filesystem operations without performing them" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "lock",
+		Short:       "Encrypt the slots of inactive profiles, so only the active ones are readable",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			exitIfUnknownProfiles(gc, params.Profile)
+			fsys := newFsOps(params.DryRun)
+			keys := newKeyring(gc.Encryption, params.Passphrase)
+
+			locked := 0
+			for _, p := range gc.Paths {
+				for _, slot := range p.DetectedProfs {
+					if slot.Sealed || (len(params.Profile) > 0 && !lo.Contains(params.Profile, slot.Name)) {
+						continue
+					}
+					if p.ResolvedTgt != nil && pathsAreEqual(p.ResolvedTgt.Path, slot.Path) {
+						if len(params.Profile) > 0 {
+							infof("WARNING: Not locking profile %s for path %s, it is active\n", slot.Name, p.SrcPath)
+						}
+						continue
+					}
+					if err := fsys.Seal(slot.Path, sealedSlotPath(p.StorageDir, slot.Name), keys); err != nil {
+						ExitWithMsg(1, fmt.Sprintf("Failed to lock profile %s for path %s: %v", slot.Name, p.SrcPath, err))
+					}
+					infof("Locked profile %s for path %s\n", slot.Name, p.SrcPath)
+					locked++
+				}
+			}
+			if locked == 0 {
+				infof("Nothing to lock\n")
+			}
+			fsys.RefreshState()
+		},
+	}.ToCobra()
+}
+
+func UnlockCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Profile []string `descr:"Only unlock these profiles (repeatable), defaults to all locked profiles" optional:"true"`
+		DryRun  bool     `descr:"Print the planned filesystem operations without performing them" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "unlock",
+		Short:       "Decrypt the slots of profiles locked by 'profs lock'",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			exitIfUnknownProfiles(gc, params.Profile)
+			fsys := newFsOps(params.DryRun)
+			keys := newKeyring(gc.Encryption, false)
+
+			unlocked := 0
+			for _, p := range gc.Paths {
+				for _, slot := range p.DetectedProfs {
+					if !slot.Sealed || (len(params.Profile) > 0 && !lo.Contains(params.Profile, slot.Name)) {
+						continue
+					}
+					if err := fsys.Unseal(slot.Path, filepath.Join(p.StorageDir, slot.Name), keys); err != nil {
+						ExitWithMsg(1, fmt.Sprintf("Failed to unlock profile %s for path %s: %v", slot.Name, p.SrcPath, err))
+					}
+					infof("Unlocked profile %s for path %s\n", slot.Name, p.SrcPath)
+					unlocked++
+				}
+			}
+			if unlocked == 0 {
+				infof("Nothing to unlock\n")
+			}
+			fsys.RefreshState()
+		},
+	}.ToCobra()
+}
+
+func exitIfUnknownProfiles(gc GlobalConfig, profiles []string) {
+	for _, profile := range profiles {
+		if !lo.Contains(gc.DetectedProfileNames(), profile) {
+			ExitWithMsg(1, fmt.Sprintf("Profile '%s' not found", profile))
+		}
+	}
+}
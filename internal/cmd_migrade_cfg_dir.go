@@ -4,13 +4,13 @@ import (
 	"fmt"
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/spf13/cobra"
-	"os"
 )
 
-func MigrateConfigDir(gc GlobalConfig) *cobra.Command {
+func MigrateConfigDir() *cobra.Command {
 
 	var params struct {
-		Yes bool `descr:"Skip confirmation prompt" flag:"yes"  default:"false"`
+		Yes    bool `descr:"Skip confirmation prompt" flag:"yes"  default:"false"`
+		DryRun bool `descr:"Print the planned filesystem operations without performing them" default:"false"`
 	}
 
 	legacyPath := LegacyConfigDirPath()
@@ -23,15 +23,16 @@ func MigrateConfigDir(gc GlobalConfig) *cobra.Command {
 		ParamEnrich: paramEnricherDefault,
 		RunFunc: func(cmd *cobra.Command, args []string) {
 
-			if !fileOrDirExists(legacyPath) {
+			if !fileOrDirExistsOrExit(legacyPath) {
 				ExitWithMsg(1, "No legacy configuration found at "+legacyPath+", nothing to migrate")
 			}
 
-			if fileOrDirExists(newPath) {
+			if fileOrDirExistsOrExit(newPath) {
 				ExitWithMsg(1, "New configuration directory already exists at "+newPath+", please remove it before migrating")
 			}
 
-			if !params.Yes {
+			fsys := newFsOps(params.DryRun)
+			if !params.Yes && !params.DryRun {
 				if !askForConfirmation(
 					"Are you sure you want to migrate the configuration dir?\n" +
 						"from legacy " + legacyPath + " -> " + newPath,
@@ -40,8 +41,8 @@ func MigrateConfigDir(gc GlobalConfig) *cobra.Command {
 				}
 			}
 
-			fmt.Printf("Migrating configuration from %s to %s\n", legacyPath, newPath)
-			err := os.Rename(legacyPath, newPath)
+			infof("Migrating configuration from %s to %s\n", legacyPath, newPath)
+			err := fsys.Rename(legacyPath, newPath)
 			if err != nil {
 				ExitWithMsg(1, fmt.Sprintf("Failed to migrate configuration: %v", err))
 			}
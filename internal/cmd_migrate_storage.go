@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/spf13/cobra"
+)
+
+func MigrateStorageCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Root string `descr:"Storage root to move profile storage into (defaults to the configured root, or ~/.local/share/profs)" optional:"true"`
+		Back bool   `descr:"Move profile storage back to sibling <path>.profs directories" default:"false"`
+		Yes  bool   `descr:"Skip confirmation prompt" flag:"yes" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "migrate-storage",
+		Short:       "Move profile storage between sibling .profs directories and a central storage root",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+
+			rawConfig := loadGlobalConfRawOrExit()
+
+			root := params.Root
+			if root == "" {
+				root = rawConfig.StorageRoot
+			}
+			if root == "" {
+				root = DefaultStorageRoot()
+			}
+			root, err := toAbsPath(root)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for storage root '%s', error: %v", params.Root, err))
+			}
+
+			type move struct {
+				path Path
+				dest string
+			}
+
+			var moves []move
+			for _, path := range gc.Paths {
+				dest := rootStorageDir(root, path.SrcPath)
+				if params.Back {
+					dest = siblingStorageDir(path.SrcPath)
+				}
+				if pathsAreEqual(path.StorageDir, dest) {
+					continue
+				}
+				if path.Status != StatusOk && path.Status != StatusErrorSrcNotFound {
+					ExitWithMsg(1, fmt.Sprintf("Cannot migrate storage of path '%s' because it is not valid: %s, aborting", path.SrcPath, path.Status))
+				}
+				if fileOrDirExistsOrExit(dest) {
+					ExitWithMsg(1, fmt.Sprintf("Cannot migrate storage of path '%s', '%s' already exists", path.SrcPath, dest))
+				}
+				moves = append(moves, move{path: path, dest: dest})
+			}
+
+			if len(moves) == 0 {
+				fmt.Println("Storage is already in the requested location, nothing to migrate")
+			}
+
+			if len(moves) > 0 && !params.Yes {
+				fmt.Println("The following storage directories will be moved:")
+				for _, m := range moves {
+					fmt.Printf("  %s -> %s\n", simplifyPath(m.path.StorageDir), simplifyPath(m.dest))
+				}
+				if !askForConfirmation("Are you sure you want to migrate profile storage?") {
+					ExitWithMsg(0, "Aborting storage migration")
+				}
+			}
+
+			for _, m := range moves {
+				fmt.Printf("Moving %s -> %s\n", simplifyPath(m.path.StorageDir), simplifyPath(m.dest))
+				migratePathStorage(m.path, m.dest)
+
+				// save after each path, so the config always matches what is on disk
+				rawConfig.setStorageDir(m.path.SrcPath, m.dest)
+				SaveGlobalConfRaw(rawConfig)
+			}
+
+			if params.Back {
+				rawConfig.StorageRoot = ""
+			} else {
+				rawConfig.StorageRoot = toConfigPath(root)
+			}
+			SaveGlobalConfRaw(rawConfig)
+			refreshState()
+		},
+	}.ToCobra()
+}
+
+// migratePathStorage moves the storage directory of a path to dest and re-points its symlink,
+// rolling back if any step fails
+func migratePathStorage(path Path, dest string) {
+	undo := rollback{}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to create directory '%s': %v", filepath.Dir(dest), err))
+	}
+
+	src := path.StorageDir
+	if err := moveFileOrDir(src, dest); err != nil {
+		exitAfterRollback(&undo, fmt.Sprintf("Failed to move '%s' to '%s': %v", src, dest, err))
+	}
+	undo.add(func() error { return moveFileOrDir(dest, src) })
+
+	if path.ResolvedTgt != nil {
+		oldTarget := path.ResolvedTgt.Path
+		newTarget := filepath.Join(dest, path.ResolvedTgt.Name)
+		if err := replaceSymlink(path.SrcPath, newTarget); err != nil {
+			exitAfterRollback(&undo, fmt.Sprintf("Failed to re-point '%s': %v", path.SrcPath, err))
+		}
+		undo.add(func() error { return replaceSymlink(path.SrcPath, oldTarget) })
+	}
+}
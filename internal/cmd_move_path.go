@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func MovePathCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		From     string `positional:"true" description:"Currently managed path"`
+		To       string `positional:"true" description:"New location of the managed path"`
+		KeepLink bool   `name:"keep-link" description:"Leave a compatibility symlink at the old location pointing to the new one" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "move-path",
+		Short:       "Relocates a managed path and its profiles",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+
+			from, err := toAbsPath(params.From)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", params.From, err))
+			}
+			to, err := toAbsPath(params.To)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", params.To, err))
+			}
+
+			rawConfig := loadGlobalConfRawOrExit()
+			idx := rawConfig.findConfigPathIndex(from)
+			if idx < 0 {
+				ExitWithMsg(1, fmt.Sprintf("Path '%s' is not managed by profs", from))
+			}
+			if rawConfig.findConfigPathIndex(to) >= 0 {
+				ExitWithMsg(1, fmt.Sprintf("Path '%s' is already managed by profs", to))
+			}
+
+			path, found := lo.Find(gc.Paths, func(p Path) bool { return pathsAreEqual(p.SrcPath, from) })
+			if !found {
+				ExitWithMsg(1, fmt.Sprintf("Path '%s' is not managed by profs", from))
+			}
+			if path.Status != StatusOk && path.Status != StatusErrorSrcNotFound {
+				ExitWithMsg(1, fmt.Sprintf("Cannot move path '%s' because it is not valid: %s, aborting", from, path.Status))
+			}
+
+			// storage next to the path follows it, storage under a storage root is re-encoded for the new path
+			fromProfsDir := path.StorageDir
+			toProfsDir := siblingStorageDir(to)
+			if !pathsAreEqual(fromProfsDir, siblingStorageDir(from)) {
+				toProfsDir = filepath.Join(filepath.Dir(fromProfsDir), encodeStoragePath(to))
+			}
+			if fileOrDirExistsOrExit(to) || isSymlinkOrExit(to) {
+				ExitWithMsg(1, fmt.Sprintf("Target path '%s' already exists, aborting", to))
+			}
+			if fileOrDirExistsOrExit(toProfsDir) {
+				ExitWithMsg(1, fmt.Sprintf("Target profs directory '%s' already exists, aborting", toProfsDir))
+			}
+
+			undo := rollback{}
+
+			if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to create parent directory of '%s': %v", to, err))
+			}
+
+			fmt.Printf("Moving %s -> %s\n", fromProfsDir, toProfsDir)
+			if err := moveFileOrDir(fromProfsDir, toProfsDir); err != nil {
+				exitAfterRollback(&undo, fmt.Sprintf("Failed to move '%s' to '%s': %v", fromProfsDir, toProfsDir, err))
+			}
+			undo.add(func() error { return moveFileOrDir(toProfsDir, fromProfsDir) })
+
+			if path.ResolvedTgt != nil {
+				oldTarget := path.ResolvedTgt.Path
+				if err := os.Remove(from); err != nil {
+					exitAfterRollback(&undo, fmt.Sprintf("Failed to remove symlink '%s': %v", from, err))
+				}
+				undo.add(func() error { return os.Symlink(oldTarget, from) })
+
+				newTarget := filepath.Join(toProfsDir, path.ResolvedTgt.Name)
+				if err := os.Symlink(newTarget, to); err != nil {
+					exitAfterRollback(&undo, fmt.Sprintf("Failed to create symlink '%s' -> '%s': %v", to, newTarget, err))
+				}
+				undo.add(func() error { return os.Remove(to) })
+			}
+
+			if params.KeepLink {
+				if err := os.Symlink(to, from); err != nil {
+					exitAfterRollback(&undo, fmt.Sprintf("Failed to create compatibility symlink '%s' -> '%s': %v", from, to, err))
+				}
+				fmt.Printf("Created compatibility symlink %s -> %s\n", from, to)
+			}
+
+			rawConfig.Paths[idx] = toConfigPath(to)
+			rawConfig.setStorageDir(from, siblingStorageDir(from))
+			rawConfig.setStorageDir(to, toProfsDir)
+			rawConfig.renamePathRefs(from, to)
+			SaveGlobalConfRaw(rawConfig)
+			refreshState()
+		},
+	}.ToCobra()
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/spf13/cobra"
+)
+
+// driftMarker is appended to the profile in prompts when the state is inconsistent
+const driftMarker = "*"
+
+var ansiColors = map[string]string{
+	"black":   "30",
+	"red":     "31",
+	"green":   "32",
+	"yellow":  "33",
+	"blue":    "34",
+	"magenta": "35",
+	"cyan":    "36",
+	"white":   "37",
+}
+
+const starshipInit = `# Add to ~/.config/starship.toml
+[custom.profs]
+command = "profs prompt"
+when = true
+format = "[$output]($style) "
+style = "bold blue"
+`
+
+const tmuxInit = `# Add to ~/.tmux.conf
+set -g status-interval 5
+set -ag status-right ' #(profs prompt --style tmux)'
+`
+
+const p10kInit = `# Add to ~/.p10k.zsh, and add 'profs' to POWERLEVEL9K_LEFT_PROMPT_ELEMENTS or POWERLEVEL9K_RIGHT_PROMPT_ELEMENTS
+function prompt_profs() {
+  local profile="$(profs prompt --style zsh)"
+  [[ -n "$profile" ]] && p10k segment -t "$profile"
+}
+`
+
+// PromptCmd doesn't take the config, it only reads the state file, to stay fast enough for shell prompts
+func PromptCmd() *cobra.Command {
+
+	var params struct {
+		Style  string `descr:"Output style" alts:"plain,ansi,tmux,zsh" strict:"true" default:"plain"`
+		Verify bool   `descr:"Verify the first managed symlink against the state, marking drift if it changed" default:"false"`
+		Init   string `descr:"Print a prompt segment snippet instead" alts:"starship,tmux,p10k" strict:"true" optional:"true"`
+	}
+
+	return boa.Cmd{
+		Use:         "prompt",
+		Short:       "Print the active profile for shell prompts and status lines",
+		Long:        "Print the active profile for shell prompts and status lines.\nReads only the state file written by profs on every change, followed by " + driftMarker + " if the state is inconsistent.",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+
+			switch params.Init {
+			case "starship":
+				fmt.Print(starshipInit)
+				return
+			case "tmux":
+				fmt.Print(tmuxInit)
+				return
+			case "p10k":
+				fmt.Print(p10kInit)
+				return
+			}
+
+			state, err := LoadState()
+			if err != nil {
+				return // no state yet, print nothing rather than break the prompt
+			}
+
+			text := state.DisplayName()
+			if text == "" {
+				return
+			}
+			if state.Drift || (params.Verify && !state.Verify()) {
+				text += driftMarker
+			}
+
+			fmt.Println(colorize(text, state.Color, params.Style))
+		},
+	}.ToCobra()
+}
+
+// colorize wraps text in the color codes of the given prompt style
+func colorize(text string, color string, style string) string {
+	if color == "" {
+		return text
+	}
+	switch style {
+	case "ansi":
+		if code, found := ansiColors[color]; found {
+			return "\033[" + code + "m" + text + "\033[0m"
+		}
+	case "tmux":
+		return "#[fg=" + color + "]" + text + "#[default]"
+	case "zsh":
+		return "%F{" + color + "}" + text + "%f"
+	}
+	return text
+}
@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func PropagateCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		File   string   `positional:"true" descr:"File within a managed path, e.g. ~/.ssh/config or ~/.gitconfig"`
+		From   string   `descr:"Profile to propagate from, defaults to the active profile of the path" optional:"true"`
+		To     []string `descr:"Profiles to propagate to (repeatable, or comma separated)" optional:"true"`
+		All    bool     `descr:"Propagate to all other profiles with a slot for the path" default:"false"`
+		DryRun bool     `descr:"Show the changes and print the planned filesystem operations without performing them" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "propagate",
+		Short:       "Merge the changes of a file in one profile into the same file of other profiles",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			if len(params.To) > 0 == params.All {
+				ExitWithMsg(ExitUsage, "Specify the profiles to propagate to with either --to or --all")
+			}
+
+			absFile, err := toAbsPath(params.File)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", params.File, err))
+			}
+			p, file, found := managedPathOf(gc, absFile)
+			if !found {
+				ExitWithMsg(1, fmt.Sprintf("'%s' is not within a path managed by profs", params.File))
+			}
+			displayName := lo.Ternary(file == ".", filepath.Base(p.SrcPath), file)
+
+			from := params.From
+			if from == "" {
+				if p.ResolvedTgt == nil {
+					ExitWithMsg(1, fmt.Sprintf("Path %s has no active profile, specify the profile to propagate from with --from", p.SrcPath))
+				}
+				from = p.ResolvedTgt.Name
+			}
+			fromSlot := slotOfOrExit(gc, p, from)
+			source := filepath.Join(fromSlot.Path, filepath.FromSlash(file))
+			sourceContent, err := os.ReadFile(source)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to read '%s' of profile %s: %v", displayName, from, err))
+			}
+			sourceInfo, err := os.Stat(source)
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to read '%s' of profile %s: %v", displayName, from, err))
+			}
+
+			var targets []DetectedProfile
+			if params.All {
+				for _, slot := range p.DetectedProfs {
+					switch {
+					case slot.Name == from:
+					case slot.Sealed:
+						infof("WARNING: Not propagating to profile %s, it is locked\n", slot.Name)
+					default:
+						targets = append(targets, slot)
+					}
+				}
+			} else {
+				for _, profile := range lo.Uniq(params.To) {
+					if profile == from {
+						ExitWithMsg(1, fmt.Sprintf("Cannot propagate from profile %s to itself", from))
+					}
+					targets = append(targets, slotOfOrExit(gc, p, profile))
+				}
+			}
+
+			bases, err := loadPropagatedBases()
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to load the last propagated versions: %v", err))
+			}
+
+			fsys := newFsOps(params.DryRun)
+			var results []PropagateTargetResult
+			conflicts := 0
+			for _, target := range targets {
+				targetFile := filepath.Join(target.Path, filepath.FromSlash(file))
+				base, hasBase := findPropagatedBase(bases, p.SrcPath, file, target.Name)
+				res, merged, err := propagateFile(sourceContent, targetFile, base, hasBase, from, target.Name)
+				if err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to propagate to profile %s: %v", target.Name, err))
+				}
+				res.Profile = target.Name
+				if !isSecretFile(displayName, sourceContent) {
+					oldContent, _ := os.ReadFile(targetFile)
+					label := path.Join(target.Name, displayName)
+					res.Diff = unifiedDiff(label, label, maskSecretValues(string(oldContent)), maskSecretValues(merged), diffContext)
+				}
+
+				switch res.Status {
+				case "conflict":
+					conflicts++
+				case "unchanged":
+				default:
+					mode := sourceInfo.Mode().Perm()
+					if targetInfo, err := os.Stat(targetFile); err == nil {
+						mode = targetInfo.Mode().Perm()
+					}
+					err := fsys.MkdirAll(filepath.Dir(targetFile), 0755)
+					if err == nil {
+						err = fsys.WriteFile(targetFile, []byte(merged), mode)
+					}
+					if err != nil {
+						ExitWithMsg(1, fmt.Sprintf("Failed to write '%s': %v", targetFile, err))
+					}
+				}
+				if res.Status != "conflict" && !params.DryRun {
+					if bases, err = setPropagatedBase(bases, p.SrcPath, file, target.Name, source); err != nil {
+						ExitWithMsg(1, fmt.Sprintf("Failed to record the propagated version: %v", err))
+					}
+				}
+				results = append(results, res)
+			}
+			if !params.DryRun {
+				if err := savePropagatedBases(bases); err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to record the propagated versions: %v", err))
+				}
+			}
+			fsys.RefreshState()
+
+			printResult(PropagateResult{
+				resultHeader: newResultHeader("propagate"),
+				File:         toConfigPath(absFile),
+				From:         from,
+				Targets:      orEmpty(results),
+			}, func() {
+				for _, res := range results {
+					fmt.Printf("%s: %s", res.Profile, res.Status)
+					if res.Kept > 0 {
+						fmt.Printf(" (kept %d regions differing from %s as they were, see 'profs diff %s %s')", res.Kept, from, from, res.Profile)
+					}
+					fmt.Println()
+					for _, line := range splitLines(res.Diff) {
+						fmt.Printf("    %s\n", line)
+					}
+				}
+			})
+			if conflicts > 0 {
+				msg := fmt.Sprintf("Not propagated to %d profiles because of conflicts, edit them manually", conflicts)
+				if machineOutput() {
+					exitWithCode(ExitFindings, msg)
+				}
+				ExitWithMsg(ExitFindings, msg)
+			}
+		},
+	}.ToCobra()
+}
+
+// propagateFile merges the source content into a target file, returning the new target content. With the
+// content last propagated to the target as base, the changes since then are merged like in git, and changes
+// to the same lines in the target are conflicts. Without a base, everything only in the target is kept, but
+// lines the source added next to lines that differ in the target are conflicts, as they can't be placed
+func propagateFile(source []byte, targetFile string, base string, hasBase bool, from string, to string) (PropagateTargetResult, string, error) {
+	old, err := os.ReadFile(targetFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return PropagateTargetResult{Status: "created"}, string(source), nil
+	case err != nil:
+		return PropagateTargetResult{}, "", err
+	case bytes.Equal(old, source):
+		return PropagateTargetResult{Status: "unchanged"}, string(old), nil
+	case hasBase && base == string(old):
+		return PropagateTargetResult{Status: "updated"}, string(source), nil
+	case !isTextFile(source) || !isTextFile(old) || (hasBase && !isTextFile([]byte(base))):
+		// binary files can only replace an unchanged target
+		return PropagateTargetResult{Status: "conflict"}, string(old), nil
+	}
+
+	if !hasBase {
+		base = commonLines(string(source), string(old))
+	}
+	merged, conflicts, kept := merge3(base, string(source), string(old), from, to, !hasBase)
+	if conflicts > 0 {
+		return PropagateTargetResult{Status: "conflict", Conflicts: conflicts, Kept: kept}, merged, nil
+	}
+	return PropagateTargetResult{Status: lo.Ternary(merged == string(old), "unchanged", "merged"), Kept: kept}, merged, nil
+}
+
+// managedPathOf returns the managed path a file is within, and the file relative to its slot, "." for the path itself
+func managedPathOf(gc GlobalConfig, absFile string) (Path, string, bool) {
+	for _, p := range gc.Paths {
+		if pathsAreEqual(p.SrcPath, absFile) {
+			return p, ".", true
+		}
+		if rel, found := strings.CutPrefix(absFile, p.SrcPath+string(filepath.Separator)); found {
+			return p, filepath.ToSlash(rel), true
+		}
+	}
+	return Path{}, "", false
+}
+
+// slotOfOrExit returns the unlocked slot of a profile for a path
+func slotOfOrExit(gc GlobalConfig, p Path, profile string) DetectedProfile {
+	if !lo.Contains(gc.DetectedProfileNames(), profile) {
+		ExitWithMsg(1, fmt.Sprintf("Profile '%s' not found", profile))
+	}
+	slot, found := lo.Find(p.DetectedProfs, func(prof DetectedProfile) bool { return prof.Name == profile })
+	if !found {
+		ExitWithMsg(1, fmt.Sprintf("Profile %s has no slot for path %s", profile, p.SrcPath))
+	}
+	if slot.Sealed {
+		ExitWithMsg(1, fmt.Sprintf("Profile '%s' is locked, run 'profs unlock --profile %s' first", profile, profile))
+	}
+	return slot
+}
@@ -7,30 +7,36 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func RemoveCmd(cmdName string, gc GlobalConfig) *cobra.Command {
+func RemoveCmd(cmdName string) *cobra.Command {
 
 	var params struct {
-		Path string `positional:"true" description:"Path to add"`
-		Yes  bool   `short:"y" name:"yes" description:"Skip confirmation prompt"`
+		Path   string `positional:"true" description:"Path to add"`
+		Yes    bool   `short:"y" name:"yes" description:"Skip confirmation prompt"`
+		DryRun bool   `descr:"Print the planned filesystem operations without performing them" default:"false"`
 	}
 
-	rawConfig := LoadGlobalConfRaw()
-	alternatives := rawConfig.Paths
-
 	return boa.Cmd{
 		Use:         cmdName,
 		Short:       "Removes a directory from profs config",
 		Long:        "Removes a directory from profs config.\nNOTE: This does not remove symlinks or directories,\nit only removes the path from the profs configuration.",
 		Params:      &params,
 		ParamEnrich: paramEnricherDefault,
-		ValidArgs:   alternatives,
+		ValidArgsFunc: func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			rawConfig, err := ReadGlobalConfRaw()
+			if err != nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return rawConfig.Paths, cobra.ShellCompDirectiveNoFileComp
+		},
 		RunFunc: func(cmd *cobra.Command, args []string) {
+			rawConfig := loadGlobalConfRawOrExit()
 
 			if !lo.Contains(rawConfig.Paths, params.Path) {
 				ExitWithMsg(1, fmt.Sprintf("Path '%s' does not exist in profs configuration", params.Path))
 			}
 
-			if !params.Yes {
+			fsys := newFsOps(params.DryRun)
+			if !params.Yes && !params.DryRun {
 				if !askForConfirmation("Are you sure you want to remove the path from profs configuration?") {
 					ExitWithMsg(0, "Aborting removal of path "+params.Path)
 				}
@@ -40,8 +46,10 @@ func RemoveCmd(cmdName string, gc GlobalConfig) *cobra.Command {
 			rawConfig.Paths = lo.Filter(rawConfig.Paths, func(p string, _ int) bool {
 				return p != params.Path
 			})
+			rawConfig.removePathRefs(expandHomePath(params.Path))
 
-			SaveGlobalConfRaw(rawConfig)
+			fsys.SaveConfig(rawConfig)
+			fsys.RefreshState()
 
 		},
 	}.ToCobra()
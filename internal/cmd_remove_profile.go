@@ -5,27 +5,29 @@ import (
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
-	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-func RemoveProfileCmd(gc GlobalConfig) *cobra.Command {
+func RemoveProfileCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
 
 	var params struct {
-		Name string `positional:"true" description:"Name of the profile to add"`
-		Yes  bool   `name:"yes" description:"Skip confirmation dialogue" default:"false"`
+		Name   string   `positional:"true" description:"Name of the profile to add"`
+		Yes    bool     `name:"yes" description:"Skip confirmation dialogue" default:"false"`
+		Path   []string `descr:"Only remove the slots of these paths (repeatable), keeping the profile for other paths" optional:"true"`
+		DryRun bool     `descr:"Print the planned filesystem operations without performing them" default:"false"`
 	}
 
-	existingProfileNames := gc.DetectedProfileNames()
-
 	return boa.Cmd{
-		Use:         "remove-profile",
-		Short:       "Removes an existing profile managed by profs",
-		Params:      &params,
-		ParamEnrich: paramEnricherDefault,
-		ValidArgs:   existingProfileNames,
+		Use:           "remove-profile",
+		Short:         "Removes an existing profile managed by profs",
+		Params:        &params,
+		ParamEnrich:   paramEnricherDefault,
+		ValidArgsFunc: lazyGc.completeProfiles,
 		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			existingProfileNames := gc.DetectedProfileNames()
 
 			// Current profiles must not collide with the name of the profile to add
 			if !lo.ContainsBy(existingProfileNames, func(item string) bool {
@@ -33,40 +35,104 @@ func RemoveProfileCmd(gc GlobalConfig) *cobra.Command {
 			}) {
 				ExitWithMsg(1, fmt.Sprintf("Profile name '%s' does not exist", params.Name))
 			}
+			exitIfSealed(gc, params.Name)
+
+			partial := len(params.Path) > 0
+			paths := gc.Paths
+			if partial {
+				paths = selectPaths(gc, params.Path, nil)
+			}
 
-			// Cannot remove an active profile
-			if lo.Contains(gc.ActiveProfileNames(), params.Name) {
+			// Cannot remove an active profile, or with --path, a slot a path currently points to
+			if !partial && lo.Contains(gc.ActiveProfileNames(), params.Name) {
 				ExitWithMsg(1, fmt.Sprintf("Cannot remove active profile '%s'. Please deactivate it first.", params.Name))
 			}
+			for _, path := range paths {
+				if path.ResolvedTgt != nil && path.ResolvedTgt.Name == params.Name {
+					ExitWithMsg(1, fmt.Sprintf("Cannot remove profile '%s' from path '%s', it is active for that path. Please switch the path to another profile first.", params.Name, path.SrcPath))
+				}
+			}
 
 			// Ask for confirmation if not skipped
-			if !params.Yes {
-				if !askForConfirmation(fmt.Sprintf("Are you sure you want to remove the profile '%s'?", params.Name)) {
+			fsys := newFsOps(params.DryRun)
+			if !params.Yes && !params.DryRun {
+				question := fmt.Sprintf("Are you sure you want to remove the profile '%s'?", params.Name)
+				if partial {
+					question = fmt.Sprintf("Are you sure you want to remove the profile '%s' from %s?", params.Name, strings.Join(lo.Map(paths, func(p Path, _ int) string { return simplifyPath(p.SrcPath) }), ", "))
+				}
+				if !askForConfirmation(question) {
 					ExitWithMsg(0, "Aborting removal of profile "+params.Name)
 				}
 			}
 
-			// Remove the profile from each path
-			for _, path := range gc.Paths {
+			// Load the config before touching the disk, its references to the profile are kept in the trash
+			rawConfig := loadGlobalConfRawOrExit()
+
+			snapshotBefore(fsys, gc, "remove-profile "+params.Name)
+
+			// Move the slot of each path into the trash, so the profile can be restored
+			entry := newTrashEntry(params.Name, time.Now())
+			if err := fsys.MkdirAll(entry.Dir, 0755); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to create trash entry '%s': %v", entry.Dir, err))
+			}
+			undo := rollback{}
+			undo.add(func() error { return fsys.RemoveAll(entry.Dir) })
+
+			for _, path := range paths {
 				profsDir, err := path.ProfsDir()
 				if err != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to get profs directory for path '%s': %v", path.SrcPath, err))
+					exitAfterRollback(&undo, fmt.Sprintf("Failed to get profs directory for path '%s': %v", path.SrcPath, err))
 				}
 
 				profileDir := filepath.Join(profsDir, params.Name)
 
-				if !fileOrDirExists(profileDir) {
-					fmt.Printf("Profile '%s' does not exist in path '%s', skipping\n", params.Name, path.SrcPath)
+				if !fileOrDirExistsOrExit(profileDir) {
+					infof("Profile '%s' does not exist in path '%s', skipping\n", params.Name, path.SrcPath)
 					continue
 				}
 
-				err = os.RemoveAll(profileDir)
-				if err != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to remove profile directory '%s' in path '%s': %v", profileDir, path.SrcPath, err))
+				slot := TrashSlot{Path: toConfigPath(path.SrcPath), StorageDir: profsDir, Name: fmt.Sprint(len(entry.Slots))}
+				trashedSlot := entry.SlotPath(slot)
+				if err := fsys.Rename(profileDir, trashedSlot); err != nil {
+					exitAfterRollback(&undo, fmt.Sprintf("Failed to move profile directory '%s' in path '%s' to the trash: %v", profileDir, path.SrcPath, err))
 				}
+				undo.add(func() error { return fsys.Rename(trashedSlot, profileDir) })
+				entry.Slots = append(entry.Slots, slot)
 
-				fmt.Printf("Removed profile '%s' from path '%s'\n", params.Name, path.SrcPath)
+				if !params.DryRun {
+					fmt.Printf("Removed profile '%s' from path '%s'\n", params.Name, path.SrcPath)
+				}
 			}
+
+			// A fully removed profile can no longer be switched to, so nothing in the config may refer to it
+			if !partial {
+				if refs := rawConfig.removeProfileRefs(params.Name); !refs.IsEmpty() {
+					entry.Refs = &refs
+					infof("Removed from the config, until the profile is restored: %s\n", strings.Join(refs.Describe(), ", "))
+				}
+			}
+
+			manifest, err := entry.Manifest()
+			if err == nil {
+				err = fsys.WriteFile(filepath.Join(entry.Dir, trashManifestFile), manifest, 0644)
+			}
+			if err != nil {
+				exitAfterRollback(&undo, fmt.Sprintf("Failed to write trash manifest: %v", err))
+			}
+			if !params.DryRun {
+				fmt.Printf("Moved profile '%s' to the trash, restore it with 'profs trash restore %s'\n", params.Name, params.Name)
+			}
+
+			// Remember that the profile was removed from these paths on purpose, so they aren't reported as missing
+			for _, slot := range entry.Slots {
+				rawConfig.setSparse(params.Name, expandHomePath(slot.Path), partial)
+			}
+			if !partial {
+				delete(rawConfig.Sparse, params.Name)
+			}
+			fsys.SaveConfig(rawConfig)
+
+			fsys.RefreshState()
 		},
 	}.ToCobra()
 }
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func RenameProfileCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		From string `positional:"true" description:"Current name of the profile"`
+		To   string `positional:"true" description:"New name of the profile"`
+	}
+
+	return boa.Cmd{
+		Use:           "rename-profile",
+		Short:         "Renames a profile across all managed paths",
+		Params:        &params,
+		ParamEnrich:   paramEnricherDefault,
+		ValidArgsFunc: lazyGc.completeProfiles,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+
+			if !lo.Contains(gc.DetectedProfileNames(), params.From) {
+				ExitWithMsg(1, fmt.Sprintf("Profile '%s' does not exist", params.From))
+			}
+			exitIfSealed(gc, params.From)
+
+			if err := validateProfileName(params.To); err != nil {
+				ExitWithMsg(1, err.Error())
+			}
+
+			// The new name must not collide with any existing profile (other than the one being renamed)
+			if lo.ContainsBy(gc.DetectedProfileNames(), func(item string) bool {
+				return item != params.From && strings.ToLower(item) == strings.ToLower(params.To)
+			}) {
+				ExitWithMsg(1, fmt.Sprintf("Profile name '%s' already exists, please choose a different name", params.To))
+			}
+
+			// Load everything to update and check all paths before touching anything
+			rawConfig := loadGlobalConfRawOrExit()
+			bases, err := loadPropagatedBases()
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to load the propagated versions: %v", err))
+			}
+			for _, path := range gc.Paths {
+				oldSlot, found := lo.Find(path.DetectedProfs, func(p DetectedProfile) bool { return p.Name == params.From })
+				if !found {
+					continue
+				}
+				newSlot := filepath.Join(filepath.Dir(oldSlot.Path), params.To)
+				if fileOrDirExistsOrExit(newSlot) && !strings.EqualFold(params.From, params.To) {
+					ExitWithMsg(1, fmt.Sprintf("Cannot rename profile for path '%s', '%s' already exists", path.SrcPath, newSlot))
+				}
+			}
+
+			undo := rollback{}
+			for _, path := range gc.Paths {
+				oldSlot, found := lo.Find(path.DetectedProfs, func(p DetectedProfile) bool { return p.Name == params.From })
+				if !found {
+					infof("Profile '%s' does not exist in path '%s', skipping\n", params.From, path.SrcPath)
+					continue
+				}
+
+				newSlotPath := filepath.Join(filepath.Dir(oldSlot.Path), params.To)
+				if err := os.Rename(oldSlot.Path, newSlotPath); err != nil {
+					exitAfterRollback(&undo, fmt.Sprintf("Failed to rename '%s' to '%s': %v", oldSlot.Path, newSlotPath, err))
+				}
+				undo.add(func() error { return os.Rename(newSlotPath, oldSlot.Path) })
+
+				// re-point the symlink if it points to the renamed profile
+				if path.ResolvedTgt != nil && path.ResolvedTgt.Name == params.From {
+					if err := replaceSymlink(path.SrcPath, newSlotPath); err != nil {
+						exitAfterRollback(&undo, fmt.Sprintf("Failed to re-point active path '%s': %v", path.SrcPath, err))
+					}
+					srcPath := path.SrcPath
+					undo.add(func() error { return replaceSymlink(srcPath, oldSlot.Path) })
+				}
+
+				infof("Renamed profile '%s' to '%s' for path '%s'\n", params.From, params.To, path.SrcPath)
+			}
+
+			// the versions last propagated to the profile stay the base of its next propagation
+			if err := savePropagatedBases(renamePropagatedBases(bases, params.From, params.To)); err != nil {
+				exitAfterRollback(&undo, fmt.Sprintf("Failed to record the propagated versions: %v", err))
+			}
+			rawConfig.renameProfileRefs(params.From, params.To)
+			SaveGlobalConfRaw(rawConfig)
+			refreshState()
+		},
+	}.ToCobra()
+}
+
+// validateProfileName checks that a profile name can be used as a directory name inside a .profs directory
+func validateProfileName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("invalid profile name '%s'", name)
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid profile name '%s', must not contain path separators", name)
+	}
+	if isReservedSlotName(name) {
+		return fmt.Errorf("invalid profile name '%s', it is reserved by profs", name)
+	}
+	return nil
+}
@@ -5,12 +5,14 @@ import (
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/spf13/cobra"
 	"os"
+	"path/filepath"
 )
 
-func ResetCmd(gc GlobalConfig) *cobra.Command {
+func ResetCmd() *cobra.Command {
 
 	var params struct {
-		Yes bool `descr:"Skip confirmation prompt" flag:"yes"  default:"false"`
+		Yes    bool `descr:"Skip confirmation prompt" flag:"yes"  default:"false"`
+		DryRun bool `descr:"Print the planned filesystem operations without performing them" default:"false"`
 	}
 
 	return boa.Cmd{
@@ -20,7 +22,8 @@ func ResetCmd(gc GlobalConfig) *cobra.Command {
 		ParamEnrich: paramEnricherDefault,
 		RunFunc: func(cmd *cobra.Command, args []string) {
 
-			if !params.Yes {
+			fsys := newFsOps(params.DryRun)
+			if !params.Yes && !params.DryRun {
 				if !askForConfirmation(
 					"Are you sure you want to reset all configurations?\n" +
 						"This will reset the configuration to zero, but all symlinks will remain intact",
@@ -35,18 +38,31 @@ func ResetCmd(gc GlobalConfig) *cobra.Command {
 				return
 			}
 
-			// delete the config directory
-			err := os.RemoveAll(configDir)
-			if err != nil {
-				ExitWithMsg(1, fmt.Sprintf("Failed to reset configuration: %v", err))
+			// the config may be broken, which is a reason to reset, so that doesn't prevent it
+			if gc, err := ReadGlobalConf(); err == nil {
+				snapshotBefore(fsys, gc, "reset")
+			} else if raw, rawErr := ReadGlobalConfRaw(); rawErr != nil || raw.AutoSnapshot {
+				infof("WARNING: Not snapshotting before reset, the config can't be loaded: %v\n", err)
 			}
 
-			// re-initialize the config directory
-			newConfigDir := ConfigDir()
-			fmt.Printf("Re-initializing configuration directory: %s\n", newConfigDir)
-			err = os.MkdirAll(newConfigDir, 0755)
+			// delete everything in the config directory, except removed profiles in the trash and snapshots
+			entries, err := os.ReadDir(configDir)
 			if err != nil {
-				ExitWithMsg(1, fmt.Sprintf("Failed to create configuration directory: %v", err))
+				ExitWithMsg(1, fmt.Sprintf("Failed to reset configuration: %v", err))
+			}
+			for _, entry := range entries {
+				entryPath := filepath.Join(configDir, entry.Name())
+				if pathsAreEqual(entryPath, TrashDir()) {
+					infof("Keeping removed profiles in %s, delete them with 'profs trash empty'\n", entryPath)
+					continue
+				}
+				if pathsAreEqual(entryPath, SnapshotsDir()) {
+					infof("Keeping snapshots in %s\n", entryPath)
+					continue
+				}
+				if err := fsys.RemoveAll(entryPath); err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to reset configuration: %v", err))
+				}
 			}
 		},
 	}.ToCobra()
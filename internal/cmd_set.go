@@ -3,16 +3,20 @@ package internal
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 )
 
-func SetCmd(gc GlobalConfig) *cobra.Command {
+func SetCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
 
 	var params struct {
-		Profile string `descr:"The profile to load" positional:"true"`
+		Profile string   `descr:"The profile or mix to load" positional:"true"`
+		Path    []string `descr:"Only switch these paths (repeatable)" optional:"true"`
+		Group   []string `descr:"Only switch the paths of these path groups from the config (repeatable)" optional:"true"`
+		DryRun  bool     `descr:"Print the planned filesystem operations without performing them" default:"false"`
 	}
 
 	return boa.Cmd{
@@ -20,54 +24,305 @@ func SetCmd(gc GlobalConfig) *cobra.Command {
 		Short:       "Set current profile",
 		Params:      &params,
 		ParamEnrich: paramEnricherDefault,
-		ValidArgs:   gc.DetectedProfileNames(),
 		InitFuncCtx: func(ctx *boa.HookContext, _ any, _ *cobra.Command) error {
-			boa.GetParamT(ctx, &params.Profile).SetAlternatives(gc.DetectedProfileNames())
+			boa.GetParamT(ctx, &params.Profile).SetAlternativesFunc(func(_ *cobra.Command, _ []string, _ string) []string {
+				gc, err := lazyGc.Load()
+				if err != nil {
+					return nil
+				}
+				return append(gc.DetectedProfileNames(), gc.MixNames()...)
+			})
+			return nil
+		},
+		PreValidateFuncCtx: func(ctx *boa.HookContext, _ any, _ *cobra.Command, _ []string) error {
+			// alternatives are only known once the config is loaded, which is deferred until the command actually runs
+			gc := lazyGc.Get()
+			boa.GetParamT(ctx, &params.Profile).SetAlternatives(append(gc.DetectedProfileNames(), gc.MixNames()...))
 			return nil
 		},
 		RunFunc: func(cmd *cobra.Command, args []string) {
-			if !lo.Contains(gc.DetectedProfileNames(), params.Profile) {
-				fmt.Println("Available profiles:")
-				for _, p := range gc.DetectedProfileNames() {
-					fmt.Printf("  %v\n", p)
-				}
-				ExitWithMsg(1, fmt.Sprintf("Profile '%s' not found", params.Profile))
+			gc := lazyGc.Get()
+			switched := switchProfile(newFsOps(params.DryRun), gc, params.Profile, params.Path, params.Group)
+			printResult(SetResult{
+				resultHeader: newResultHeader("set"),
+				Profile:      params.Profile,
+				Paths: orEmpty(lo.Map(switched, func(st slotTarget, _ int) SwitchedPathResult {
+					return SwitchedPathResult{Path: st.path.SrcPath, Profile: st.profile, Target: st.target}
+				})),
+			}, nil)
+		},
+	}.ToCobra()
+}
+
+// switchProfile switches to a profile or mix, optionally only for the given paths and path groups,
+// returning the slot each switched path now points to
+func switchProfile(fsys fsOps, gc GlobalConfig, profile string, pathArgs []string, groupArgs []string) []slotTarget {
+	if !lo.Contains(gc.DetectedProfileNames(), profile) && lo.Contains(gc.MixNames(), profile) {
+		if len(pathArgs) > 0 || len(groupArgs) > 0 {
+			ExitWithMsg(1, fmt.Sprintf("Cannot use --path or --group with mix '%s'", profile))
+		}
+		return setMix(fsys, gc, profile)
+	}
+
+	if !lo.Contains(gc.DetectedProfileNames(), profile) {
+		infof("Available profiles:\n")
+		for _, p := range gc.DetectedProfileNames() {
+			infof("  %v\n", p)
+		}
+		ExitWithMsg(1, fmt.Sprintf("Profile '%s' not found", profile))
+	}
+
+	partial := len(pathArgs) > 0 || len(groupArgs) > 0
+	paths := gc.Paths
+	if partial {
+		paths = selectPaths(gc, pathArgs, groupArgs)
+	}
+
+	plan := planProfileSwitch(gc, paths, func(Path) string { return profile })
+	applyProfileSwitch(fsys, gc, plan)
+
+	// Remember which paths were intentionally switched separately from the rest
+	rawConfig := loadGlobalConfRawOrExit()
+	if !partial {
+		rawConfig.Overrides = nil
+		// paths the profile doesn't manage stay on their profile on purpose
+		for _, p := range paths {
+			if gc.IsSparse(profile, p) && p.ResolvedTgt != nil && p.ResolvedTgt.Name != profile {
+				rawConfig.setOverride(p.SrcPath, p.ResolvedTgt.Name)
 			}
+		}
+	} else {
+		// only the paths actually switched, to the profile they got, which may be a fallback
+		baseProfiles := gc.BaseProfileNames()
+		for _, st := range plan {
+			if len(baseProfiles) == 1 && baseProfiles[0] == st.profile {
+				rawConfig.setOverride(st.path.SrcPath, "")
+			} else {
+				rawConfig.setOverride(st.path.SrcPath, st.profile)
+			}
+		}
+	}
+	fsys.SaveConfig(rawConfig)
+	fsys.RefreshState()
+	sealInactiveSlots(fsys, gc, plan)
+	return plan
+}
 
-			for _, p := range gc.Paths {
-				fmt.Printf("Setting profile %v for path %s\n", params.Profile, p.SrcPath)
-				if p.Status != StatusOk && p.Status != StatusErrorTgtNotFound && p.Status != StatusErrorSrcNotFound {
-					fmt.Printf("WARNING: Unable to set profile %v for path %s, because it has status %v\n", params.Profile, p.SrcPath, p.Status)
-					continue
-				}
+// setMix applies the profile of each path in a mix
+func setMix(fsys fsOps, gc GlobalConfig, mix string) []slotTarget {
+	entries, err := gc.MixEntries(mix)
+	if err != nil {
+		ExitWithMsg(1, err.Error())
+	}
+	profileOf := func(p Path) string {
+		e, _ := lo.Find(entries, func(e MixEntry) bool { return e.Path.SrcPath == p.SrcPath })
+		return e.Profile
+	}
+	plan := planProfileSwitch(gc, lo.Map(entries, func(e MixEntry, _ int) Path { return e.Path }), profileOf)
+	applyProfileSwitch(fsys, gc, plan)
 
-				tgt, found := lo.Find(p.DetectedProfs, func(prof DetectedProfile) bool {
-					return prof.Name == params.Profile
-				})
+	// the mix itself describes the intended state, so partial switch records no longer apply
+	rawConfig := loadGlobalConfRawOrExit()
+	rawConfig.Overrides = nil
+	fsys.SaveConfig(rawConfig)
+	fsys.RefreshState()
+	sealInactiveSlots(fsys, gc, plan)
+	return plan
+}
 
-				if !found {
-					fmt.Printf("WARNING: Unable to set profile %v for path %s, because it is not detected\n", params.Profile, p.SrcPath)
-					continue
-				}
+// selectPaths returns the managed paths matching the given paths and path groups
+func selectPaths(gc GlobalConfig, paths []string, groups []string) []Path {
+	var wanted []string
+	for _, p := range paths {
+		absPath, err := toAbsPath(p)
+		if err != nil {
+			ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", p, err))
+		}
+		wanted = append(wanted, absPath)
+	}
+	for _, group := range groups {
+		groupPaths, err := gc.PathsOfGroup(group)
+		if err != nil {
+			ExitWithMsg(1, err.Error())
+		}
+		wanted = append(wanted, groupPaths...)
+	}
 
-				if fileOrDirExists(p.SrcPath) && !isSymlink(p.SrcPath) {
-					ExitWithMsg(1, fmt.Sprintf("Path %s already exists and is not a symlink, please remove it before setting the profile", p.SrcPath))
-				}
+	var result []Path
+	for _, w := range lo.Uniq(wanted) {
+		p, found := lo.Find(gc.Paths, func(p Path) bool { return pathsAreEqual(p.SrcPath, w) })
+		if !found {
+			ExitWithMsg(1, fmt.Sprintf("Path '%s' is not managed by profs", w))
+		}
+		result = append(result, p)
+	}
+	return result
+}
 
-				// remove existing symlink
-				if fileOrDirExists(p.SrcPath) {
-					err := os.Remove(p.SrcPath)
-					if err != nil {
-						ExitWithMsg(1, fmt.Sprintf("Failed to remove existing symlink: %v", err))
-					}
-				}
+// slotTarget is the planned symlink target of a path when switching profiles
+type slotTarget struct {
+	path    Path
+	profile string
+	target  string
+	// sealed is the sealed file of the target slot, if it is locked
+	sealed string
+}
 
-				// create new symlink
-				err := os.Symlink(tgt.Path, p.SrcPath)
-				if err != nil {
-					ExitWithMsg(1, fmt.Sprintf("Failed to create symlink for profile %s: %v", params.Profile, err))
+// slotTargetOf links a path to one of its detected slots, which is unlocked when applying the switch if needed
+func slotTargetOf(p Path, profile string, slot DetectedProfile) slotTarget {
+	if slot.Sealed {
+		return slotTarget{path: p, profile: profile, target: filepath.Join(p.StorageDir, slot.Name), sealed: slot.Path}
+	}
+	return slotTarget{path: p, profile: profile, target: slot.Path}
+}
+
+// planProfileSwitch determines the slot each path should be linked to when switching to the given
+// profile, applying each path's missing slot policy. Nothing is changed on disk, so a "fail"
+// policy aborts the switch before any path has been touched. Paths the profile is declared as
+// not managing are left as they are.
+func planProfileSwitch(gc GlobalConfig, paths []Path, profileOf func(Path) string) []slotTarget {
+	var plan []slotTarget
+	for _, p := range paths {
+		profile := profileOf(p)
+		if gc.IsSparse(profile, p) {
+			infof("Profile %v does not manage path %s, leaving it unchanged\n", profile, p.SrcPath)
+			continue
+		}
+		if p.Status != StatusOk && p.Status != StatusEmpty && p.Status != StatusErrorTgtNotFound && p.Status != StatusErrorSrcNotFound && p.Status != StatusErrorWrongSlotType {
+			infof("WARNING: Unable to set profile %v for path %s, because it has status %v\n", profile, p.SrcPath, p.Status)
+			continue
+		}
+
+		if tgt, found := lo.Find(p.DetectedProfs, func(prof DetectedProfile) bool { return prof.Name == profile }); found {
+			plan = append(plan, slotTargetOf(p, profile, tgt))
+			continue
+		}
+
+		policy, fallback, err := parseMissingSlotPolicy(p.MissingSlot)
+		if err != nil {
+			ExitWithMsg(1, fmt.Sprintf("Invalid config for path %s: %v", p.SrcPath, err))
+		}
+
+		switch policy {
+		case MissingSlotFail:
+			ExitWithMsg(1, fmt.Sprintf("Profile %v has no slot for path %s, aborting (missing slot policy: fail)", profile, p.SrcPath))
+		case MissingSlotFallback:
+			tgt, found := lo.Find(p.DetectedProfs, func(prof DetectedProfile) bool { return prof.Name == fallback })
+			if !found {
+				ExitWithMsg(1, fmt.Sprintf("Profile %v has no slot for path %s, and fallback profile %v does not exist either, aborting", profile, p.SrcPath, fallback))
+			}
+			infof("Profile %v has no slot for path %s, using fallback profile %v\n", profile, p.SrcPath, fallback)
+			plan = append(plan, slotTargetOf(p, fallback, tgt))
+		case MissingSlotEmpty:
+			infof("Profile %v has no slot for path %s, using an empty placeholder\n", profile, p.SrcPath)
+			plan = append(plan, slotTarget{path: p, profile: profile, target: filepath.Join(p.StorageDir, emptySlotName)})
+		default:
+			infof("WARNING: Unable to set profile %v for path %s, because it is not detected\n", profile, p.SrcPath)
+		}
+	}
+	return plan
+}
+
+// applyProfileSwitch links each path to its planned slot. With versioning in the config, the storage
+// directory is committed before switching away from a profile, and before anything is unlocked. Locked
+// target slots are all unlocked before any path is linked, so a wrong passphrase leaves every path as it was
+func applyProfileSwitch(fsys fsOps, gc GlobalConfig, plan []slotTarget) {
+	if gc.Versioning {
+		for _, st := range plan {
+			if st.path.ResolvedTgt != nil && !pathsAreEqual(st.path.ResolvedTgt.Path, st.target) {
+				message := fmt.Sprintf("Switch %s from %s to %s", toConfigPath(st.path.SrcPath), st.path.ResolvedTgt.Name, st.profile)
+				if err := commitStorage(fsys, st.path.StorageDir, message); err != nil {
+					infof("WARNING: Failed to record the history of path %s: %v\n", st.path.SrcPath, err)
 				}
 			}
-		},
-	}.ToCobra()
+		}
+	}
+
+	keys := newKeyring(gc.Encryption, false)
+	undo := rollback{}
+	for _, st := range plan {
+		if st.sealed == "" {
+			continue
+		}
+		if err := fsys.Unseal(st.sealed, st.target, keys); err != nil {
+			exitAfterRollback(&undo, fmt.Sprintf("Failed to unlock profile %v for path %s: %v", st.profile, st.path.SrcPath, err))
+		}
+		undo.add(func() error { return fsys.Seal(st.target, st.sealed, keys) })
+	}
+
+	for _, st := range plan {
+		infof("Setting profile %v for path %s\n", st.profile, st.path.SrcPath)
+
+		if filepath.Base(st.target) == emptySlotName {
+			if err := ensureEmptySlot(fsys, st.path, st.target); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to create empty placeholder for path %s: %v", st.path.SrcPath, err))
+			}
+		}
+
+		linkPathToSlot(fsys, st.path, st.target)
+	}
+}
+
+// sealInactiveSlots locks the slots switched away from, with sealInactive in the config. It runs once the
+// switch is saved, so a failure only leaves a slot unlocked, and is reported as a warning
+func sealInactiveSlots(fsys fsOps, gc GlobalConfig, plan []slotTarget) {
+	if !gc.Encryption.SealInactive {
+		return
+	}
+	keys := newKeyring(gc.Encryption, false)
+	failed := false
+	for _, st := range plan {
+		for _, slot := range st.path.DetectedProfs {
+			if slot.Sealed || pathsAreEqual(slot.Path, st.target) {
+				continue
+			}
+			if err := fsys.Seal(slot.Path, sealedSlotPath(st.path.StorageDir, slot.Name), keys); err != nil {
+				infof("WARNING: Failed to lock profile %v for path %s: %v\n", slot.Name, st.path.SrcPath, err)
+				failed = true
+				continue
+			}
+			infof("Locked profile %v for path %s\n", slot.Name, st.path.SrcPath)
+		}
+	}
+	if failed {
+		infof("WARNING: Some inactive profiles are not locked, lock them with 'profs lock'\n")
+	}
+	fsys.RefreshState()
+}
+
+// ensureEmptySlot creates the empty placeholder for a path, as a directory or an
+// empty file depending on what the other slots of the path are
+func ensureEmptySlot(fsys fsOps, p Path, target string) error {
+	if fileOrDirExistsOrExit(target) {
+		return nil
+	}
+	isDir := len(p.DetectedProfs) == 0 || lo.ContainsBy(p.DetectedProfs, func(prof DetectedProfile) bool {
+		stat, err := os.Stat(prof.Path)
+		return err == nil && stat.IsDir()
+	})
+	if isDir {
+		return fsys.MkdirAll(target, 0755)
+	}
+	return fsys.WriteFile(target, []byte{}, 0644)
+}
+
+// linkPathToSlot points a managed path to the given slot
+func linkPathToSlot(fsys fsOps, p Path, target string) {
+	if fileOrDirExistsOrExit(p.SrcPath) && !isSymlinkOrExit(p.SrcPath) {
+		ExitWithMsg(1, fmt.Sprintf("Path %s already exists and is not a symlink, please remove it before setting the profile", p.SrcPath))
+	}
+
+	// remove existing symlink
+	if fileOrDirExistsOrExit(p.SrcPath) {
+		err := fsys.Remove(p.SrcPath)
+		if err != nil {
+			ExitWithMsg(1, fmt.Sprintf("Failed to remove existing symlink: %v", err))
+		}
+	}
+
+	// create new symlink
+	err := fsys.Symlink(target, p.SrcPath)
+	if err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to create symlink to %s: %v", target, err))
+	}
 }
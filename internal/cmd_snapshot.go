@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func SnapshotCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+	return boa.Cmd{
+		Use:   "snapshot",
+		Short: "Create, list, compare and restore point-in-time copies of all profiles",
+		SubCmds: []*cobra.Command{
+			snapshotCreateCmd(lazyGc),
+			snapshotListCmd(),
+			snapshotDiffCmd(),
+			snapshotRestoreCmd(lazyGc),
+		},
+	}.ToCobra()
+}
+
+func snapshotCreateCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Message string `short:"m" descr:"Description of the snapshot" optional:"true"`
+	}
+
+	return boa.Cmd{
+		Use:         "create",
+		Short:       "Snapshot all slots of all profiles, and the config",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			snapshot, err := createSnapshot(gc, params.Message, time.Now())
+			if err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to create snapshot: %v", err))
+			}
+
+			printResult(SnapshotCreateResult{
+				resultHeader: newResultHeader("snapshot-create"),
+				ID:           snapshot.ID,
+				Slots:        len(snapshot.Slots),
+			}, func() {
+				fmt.Printf("Created snapshot %s of %d slots\n", snapshot.ID, len(snapshot.Slots))
+			})
+		},
+	}.ToCobra()
+}
+
+func snapshotListCmd() *cobra.Command {
+	return boa.Cmd{
+		Use:   "list",
+		Short: "List snapshots, oldest first",
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			snapshots := listSnapshotsOrExit()
+
+			printResult(SnapshotListResult{
+				resultHeader: newResultHeader("snapshot-list"),
+				Snapshots: lo.Map(snapshots, func(s Snapshot, _ int) SnapshotEntryResult {
+					return SnapshotEntryResult{ID: s.ID, CreatedAt: s.CreatedAt, Message: s.Message, Slots: len(s.Slots)}
+				}),
+			}, func() {
+				if len(snapshots) == 0 {
+					fmt.Println("No snapshots")
+					return
+				}
+				for _, s := range snapshots {
+					fmt.Printf("%s  %s  %d slots  %s\n", s.ID, s.CreatedAt.Local().Format(time.DateTime), len(s.Slots), s.Message)
+				}
+			})
+		},
+	}.ToCobra()
+}
+
+func snapshotDiffCmd() *cobra.Command {
+
+	var params struct {
+		From string `positional:"true" descr:"Snapshot to compare from"`
+		To   string `positional:"true" descr:"Snapshot to compare to"`
+	}
+
+	return boa.Cmd{
+		Use:         "diff",
+		Short:       "Show the slots and files added, removed or changed between two snapshots",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			snapshots := listSnapshotsOrExit()
+			from, err := findSnapshot(snapshots, params.From)
+			if err != nil {
+				ExitWithMsg(1, err.Error())
+			}
+			to, err := findSnapshot(snapshots, params.To)
+			if err != nil {
+				ExitWithMsg(1, err.Error())
+			}
+			changes := diffSnapshots(from, to)
+
+			printResult(SnapshotDiffResult{
+				resultHeader: newResultHeader("snapshot-diff"),
+				From:         from.ID,
+				To:           to.ID,
+				Changes:      changes,
+			}, func() {
+				if len(changes) == 0 {
+					fmt.Printf("No differences between %s and %s\n", from.ID, to.ID)
+				}
+				signs := map[string]string{"added": "+", "removed": "-", "changed": "~"}
+				for _, c := range changes {
+					fmt.Printf("%s %s %s\n", signs[c.Change], c.Path, path.Join(c.Profile, c.File))
+				}
+			})
+		},
+	}.ToCobra()
+}
+
+func snapshotRestoreCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		ID      string   `positional:"true" descr:"Snapshot to restore, as shown by 'profs snapshot list'. Unique prefixes are accepted"`
+		Profile []string `descr:"Only restore the slots of these profiles (repeatable)" optional:"true"`
+		Path    []string `descr:"Only restore the slots of these paths (repeatable)" optional:"true"`
+		DryRun  bool     `descr:"Print the planned filesystem operations without performing them" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "restore",
+		Short:       "Restore slots, and without --profile or --path also the config, from a snapshot",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			snapshot, err := findSnapshot(listSnapshotsOrExit(), params.ID)
+			if err != nil {
+				ExitWithMsg(1, err.Error())
+			}
+
+			var wantedPaths []string
+			for _, p := range params.Path {
+				absPath, err := toAbsPath(p)
+				if err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to get absolute path for '%s', error: %v", p, err))
+				}
+				wantedPaths = append(wantedPaths, absPath)
+			}
+			slots := lo.Filter(snapshot.Slots, func(s SnapshotSlot, _ int) bool {
+				return (len(params.Profile) == 0 || lo.Contains(params.Profile, s.Profile)) &&
+					(len(wantedPaths) == 0 || lo.ContainsBy(wantedPaths, func(p string) bool { return pathsAreEqual(p, expandHomePath(s.Path)) }))
+			})
+			if len(slots) == 0 {
+				ExitWithMsg(1, fmt.Sprintf("Snapshot %s has no slots matching the given profiles and paths", snapshot.ID))
+			}
+			full := len(params.Profile) == 0 && len(params.Path) == 0
+
+			// the current state is snapshotted first, so that the restore can be undone the same way
+			fsys := newFsOps(params.DryRun)
+			if err := fsys.Snapshot(gc, "Before restoring snapshot "+snapshot.ID); err != nil {
+				ExitWithMsg(1, fmt.Sprintf("Failed to snapshot the current state: %v", err))
+			}
+
+			for _, slot := range slots {
+				storageDir := slot.StorageDir
+				if p, managed := lo.Find(gc.Paths, func(p Path) bool { return pathsAreEqual(p.SrcPath, expandHomePath(slot.Path)) }); managed {
+					storageDir = p.StorageDir // the storage may have been migrated since
+				} else if !full {
+					infof("WARNING: Path '%s' is no longer managed by profs, restoring its slot to %s anyway\n", slot.Path, storageDir)
+				}
+				if err := restoreSnapshotSlot(fsys, slot, storageDir); err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to restore profile %s for path %s: %v", slot.Profile, slot.Path, err))
+				}
+				if !params.DryRun {
+					infof("Restored profile %s for path %s\n", slot.Profile, slot.Path)
+				}
+			}
+
+			if full {
+				config, err := os.ReadFile(snapshotObjectPath(snapshot.Config))
+				if err == nil {
+					err = fsys.WriteFile(GlobalConfigPath(), config, 0644)
+				}
+				if err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to restore config: %v", err))
+				}
+				if !params.DryRun {
+					infof("Restored config %s\n", GlobalConfigPath())
+				}
+			}
+			fsys.RefreshState()
+
+			printResult(SnapshotRestoreResult{
+				resultHeader: newResultHeader("snapshot-restore"),
+				ID:           snapshot.ID,
+				Slots: lo.Map(slots, func(s SnapshotSlot, _ int) SnapshotSlotResult {
+					return SnapshotSlotResult{Path: s.Path, Profile: s.Profile}
+				}),
+				Config: full,
+			}, nil)
+		},
+	}.ToCobra()
+}
+
+// snapshotBefore snapshots all profiles before a destructive command like "remove-profile work", if enabled in the config
+func snapshotBefore(fsys fsOps, gc GlobalConfig, command string) {
+	if !gc.AutoSnapshot {
+		return
+	}
+	if err := fsys.Snapshot(gc, "Before "+command); err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to snapshot before %s, disable autoSnapshot in the config to continue anyway: %v", command, err))
+	}
+}
+
+func listSnapshotsOrExit() []Snapshot {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to read snapshots: %v", err))
+	}
+	return snapshots
+}
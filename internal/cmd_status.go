@@ -6,14 +6,20 @@ import (
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
+	"os"
 	"strings"
 )
 
-func StatusCmd(gc GlobalConfig) *cobra.Command {
+func StatusCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
 	return boa.Cmd{
 		Use:   "status",
 		Short: "Show current status",
 		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+			if machineOutput() {
+				printResult(statusResultOf(gc), nil)
+				return
+			}
 			profileOf := func(p Path) string {
 				if p.ResolvedTgt != nil {
 					return p.ResolvedTgt.Name
@@ -43,9 +49,12 @@ func StatusCmd(gc GlobalConfig) *cobra.Command {
 						src += strings.Repeat(" ", longestSrc-len(src))
 					}
 
-					infoStr := ""
+					infoStr := "[" + string(p.Status) + "]"
 					if p.TgtPath != nil {
-						infoStr = infoStr + simplifyPath(*p.TgtPath) + " [" + string(p.Status) + "]"
+						infoStr = simplifyPath(*p.TgtPath) + " " + infoStr
+					}
+					if p.Error != "" {
+						infoStr += " " + p.Error
 					}
 					fmt.Printf("  %v -> %v\n", src, infoStr)
 				}
@@ -54,23 +63,39 @@ func StatusCmd(gc GlobalConfig) *cobra.Command {
 	}.ToCobra()
 }
 
-func StatusProfileCmd(gc GlobalConfig) *cobra.Command {
+func StatusProfileCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
 	return boa.Cmd{
 		Use:   "status-profile",
 		Short: "Show current profile status",
 		RunFunc: func(cmd *cobra.Command, args []string) {
-			profileNames := gc.ActiveProfileNames()
-			if len(gc.ActiveProfileNames()) == 0 {
+			gc := lazyGc.Get()
+			if machineOutput() {
+				printResult(statusProfileResultOf(gc), nil)
+				return
+			}
+			switch state, profile := gc.ActiveState(); state {
+			case "none":
 				fmt.Println("No active profiles")
-			} else if len(gc.ActiveProfileNames()) == 1 {
-				fmt.Println(profileNames[0])
+			case "single":
+				fmt.Println(profile)
+				if !gc.AllProfilesResolved() {
+					fmt.Println("WARNING: Not all configured profile resolved!")
+					fmt.Println(" -> Run 'profs status-all' to see full profile status")
+				}
+			case "mix":
+				fmt.Println(profile)
+			case "mixed":
+				fmt.Println(profile)
+				for _, p := range gc.OverriddenPaths() {
+					fmt.Printf("  %v -> %v\n", simplifyPath(p.SrcPath), p.OverrideProfile)
+				}
 				if !gc.AllProfilesResolved() {
 					fmt.Println("WARNING: Not all configured profile resolved!")
 					fmt.Println(" -> Run 'profs status-all' to see full profile status")
 				}
-			} else {
+			default:
 				fmt.Println("WARNING: Multiple active profiles:")
-				for _, p := range profileNames {
+				for _, p := range gc.ActiveProfileNames() {
 					fmt.Printf("  %v\n", p)
 				}
 				fmt.Println(" -> Run 'profs show-all' to see full profile status")
@@ -79,7 +104,7 @@ func StatusProfileCmd(gc GlobalConfig) *cobra.Command {
 	}.ToCobra()
 }
 
-func StatusRawCmd(gc GlobalConfig) *cobra.Command {
+func StatusRawCmd() *cobra.Command {
 	var params struct {
 		Raw bool `descr:"Show raw json config on disk" default:"false"`
 	}
@@ -89,24 +114,48 @@ func StatusRawCmd(gc GlobalConfig) *cobra.Command {
 		Short:  "Show current raw configuration",
 		Params: &params,
 		RunFunc: func(cmd *cobra.Command, args []string) {
-			rawConf := LoadGlobalConfRaw()
-			bs, err := json.MarshalIndent(rawConf, "", "  ")
+			rawConf, err := ReadGlobalConfRaw()
 			if err != nil {
-				fmt.Println("Error marshalling raw config:", err)
-				return
+				// show what is on disk, so a broken config can be fixed by hand
+				if bs, readErr := os.ReadFile(GlobalConfigPath()); readErr == nil {
+					fmt.Println(string(bs))
+				}
+				ExitWithMsg(ExitConfig, err.Error())
 			}
+			printResult(rawConf, func() {
+				bs, err := json.MarshalIndent(rawConf, "", "  ")
+				if err != nil {
+					fmt.Println("Error marshalling raw config:", err)
+					return
+				}
 
-			fmt.Println(string(bs))
+				fmt.Println(string(bs))
+			})
 		},
 	}.ToCobra()
 }
 
-func FullStatusCmd(gc GlobalConfig) *cobra.Command {
+func FullStatusCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
 	return boa.Cmd{
 		Use:   "status-full",
 		Short: "Show full status and alternatives",
 		RunFunc: func(cmd *cobra.Command, args []string) {
-			fmt.Println(PrettyJson(gc))
+			gc := lazyGc.Get()
+			// internal structures for debugging, unlike 'status' the format is not stable
+			printResult(gc, func() { fmt.Println(PrettyJson(gc)) })
 		},
 	}.ToCobra()
 }
+
+func statusProfileResultOf(gc GlobalConfig) StatusProfileResult {
+	result := StatusProfileResult{
+		resultHeader:   newResultHeader("status-profile"),
+		ActiveProfiles: orEmpty(gc.ActiveProfileNames()),
+		Resolved:       gc.AllProfilesResolved(),
+		Overrides: orEmpty(lo.Map(gc.OverriddenPaths(), func(p Path, _ int) OverrideResult {
+			return OverrideResult{Path: p.SrcPath, Profile: p.OverrideProfile}
+		})),
+	}
+	result.State, result.Profile = gc.ActiveState()
+	return result
+}
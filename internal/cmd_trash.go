@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GiGurra/boa/pkg/boa"
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+)
+
+func TrashCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+	return boa.Cmd{
+		Use:   "trash",
+		Short: "List, restore or permanently delete profiles removed by remove-profile",
+		SubCmds: []*cobra.Command{
+			trashListCmd(),
+			trashRestoreCmd(lazyGc),
+			trashEmptyCmd(),
+		},
+	}.ToCobra()
+}
+
+func trashListCmd() *cobra.Command {
+	return boa.Cmd{
+		Use:   "list",
+		Short: "List removed profiles",
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			entries := listTrashOrExit()
+
+			result := TrashListResult{
+				resultHeader: newResultHeader("trash-list"),
+				Entries: lo.Map(entries, func(e TrashEntry, _ int) TrashEntryResult {
+					return TrashEntryResult{
+						ID:        e.ID(),
+						Profile:   e.Profile,
+						RemovedAt: e.RemovedAt,
+						Paths:     lo.Map(e.Slots, func(s TrashSlot, _ int) string { return s.Path }),
+					}
+				}),
+			}
+			printResult(result, func() {
+				if len(entries) == 0 {
+					fmt.Println("Trash is empty")
+					return
+				}
+				for _, e := range entries {
+					fmt.Printf("%s (removed %s)\n", e.Profile, e.RemovedAt.Local().Format(time.DateTime))
+					for _, s := range e.Slots {
+						fmt.Printf("  %s\n", s.Path)
+					}
+				}
+			})
+		},
+	}.ToCobra()
+}
+
+func trashRestoreCmd(lazyGc *LazyGlobalConfig) *cobra.Command {
+
+	var params struct {
+		Profile string `positional:"true" descr:"Profile to restore, the most recently removed one if removed several times"`
+	}
+
+	return boa.Cmd{
+		Use:         "restore",
+		Short:       "Restore a removed profile to the paths it was removed from",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		ValidArgsFunc: func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+			entries, err := ListTrash()
+			if err != nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return lo.Uniq(lo.Map(entries, func(e TrashEntry, _ int) string { return e.Profile })), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			gc := lazyGc.Get()
+
+			entry, _, found := lo.FindLastIndexOf(listTrashOrExit(), func(e TrashEntry) bool {
+				return e.Profile == params.Profile || e.ID() == params.Profile
+			})
+			if !found {
+				ExitWithMsg(1, fmt.Sprintf("Profile '%s' is not in the trash", params.Profile))
+			}
+
+			// Check all slots before restoring anything
+			type restore struct {
+				from string
+				to   string
+			}
+			var restores []restore
+			for _, slot := range entry.Slots {
+				storageDir := slot.StorageDir
+				if path, managed := lo.Find(gc.Paths, func(p Path) bool { return pathsAreEqual(p.SrcPath, expandHomePath(slot.Path)) }); managed {
+					storageDir = path.StorageDir // the storage may have been migrated since
+				} else {
+					fmt.Printf("WARNING: Path '%s' is no longer managed by profs, restoring its slot to %s anyway\n", slot.Path, storageDir)
+				}
+				to := filepath.Join(storageDir, entry.Profile)
+				if fileOrDirExistsOrExit(to) {
+					ExitWithMsg(1, fmt.Sprintf("Cannot restore profile '%s', '%s' already exists", entry.Profile, to))
+				}
+				restores = append(restores, restore{from: entry.SlotPath(slot), to: to})
+			}
+
+			undo := rollback{}
+			for _, r := range restores {
+				if err := os.MkdirAll(filepath.Dir(r.to), 0755); err != nil {
+					exitAfterRollback(&undo, fmt.Sprintf("Failed to create directory for '%s': %v", r.to, err))
+				}
+				if err := moveFileOrDir(r.from, r.to); err != nil {
+					exitAfterRollback(&undo, fmt.Sprintf("Failed to restore '%s': %v", r.to, err))
+				}
+				undo.add(func() error { return moveFileOrDir(r.to, r.from) })
+				fmt.Printf("Restored %s\n", r.to)
+			}
+
+			rawConfig := loadGlobalConfRawOrExit()
+			for _, slot := range entry.Slots {
+				rawConfig.setSparse(entry.Profile, expandHomePath(slot.Path), false)
+			}
+			if entry.Refs != nil {
+				rawConfig.restoreProfileRefs(*entry.Refs, entry.Profile)
+				fmt.Printf("Restored in the config: %s\n", strings.Join(entry.Refs.Describe(), ", "))
+			}
+			SaveGlobalConfRaw(rawConfig)
+
+			if err := os.RemoveAll(entry.Dir); err != nil {
+				fmt.Printf("WARNING: Failed to remove trash entry '%s': %v\n", entry.Dir, err)
+			}
+			refreshState()
+		},
+	}.ToCobra()
+}
+
+func trashEmptyCmd() *cobra.Command {
+
+	var params struct {
+		OlderThan string `descr:"Only delete profiles removed longer ago than this, e.g. 30d, 2w or 12h" optional:"true"`
+		Yes       bool   `descr:"Skip confirmation prompt" flag:"yes" default:"false"`
+	}
+
+	return boa.Cmd{
+		Use:         "empty",
+		Short:       "Permanently delete removed profiles",
+		Params:      &params,
+		ParamEnrich: paramEnricherDefault,
+		RunFunc: func(cmd *cobra.Command, args []string) {
+			entries := listTrashOrExit()
+
+			if params.OlderThan != "" {
+				age, err := parseAge(params.OlderThan)
+				if err != nil {
+					ExitWithMsg(ExitUsage, err.Error())
+				}
+				cutoff := time.Now().Add(-age)
+				entries = lo.Filter(entries, func(e TrashEntry, _ int) bool { return e.RemovedAt.Before(cutoff) })
+			}
+
+			if len(entries) == 0 {
+				fmt.Println("Nothing to delete")
+				return
+			}
+
+			if !params.Yes {
+				names := lo.Map(entries, func(e TrashEntry, _ int) string { return e.Profile })
+				if !askForConfirmation(fmt.Sprintf("Are you sure you want to permanently delete %d removed profile(s): %v?", len(entries), names)) {
+					ExitWithMsg(0, "Aborting emptying the trash")
+				}
+			}
+
+			for _, e := range entries {
+				if err := os.RemoveAll(e.Dir); err != nil {
+					ExitWithMsg(1, fmt.Sprintf("Failed to delete trash entry '%s': %v", e.Dir, err))
+				}
+				fmt.Printf("Deleted %s (removed %s)\n", e.Profile, e.RemovedAt.Local().Format(time.DateTime))
+			}
+		},
+	}.ToCobra()
+}
+
+func listTrashOrExit() []TrashEntry {
+	entries, err := ListTrash()
+	if err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to read trash: %v", err))
+	}
+	return entries
+}
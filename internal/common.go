@@ -18,7 +18,11 @@ func ExitWithMsg(code int, msg string) {
 	if TestMode {
 		panic("ExitWithMsg called in test mode, code: " + fmt.Sprint(code) + ", msg: " + msg)
 	} else {
-		fmt.Println(msg)
+		if machineOutput() && code != ExitOk {
+			printResult(ErrorResult{resultHeader: newResultHeader("error"), Code: code, Message: msg}, nil)
+		} else {
+			fmt.Println(msg)
+		}
 		os.Exit(code)
 	}
 }
@@ -32,42 +36,51 @@ func PrettyJson[T any](t T) string {
 	return string(bytes)
 }
 
-func fileOrDirExists(path string) bool {
+// fileOrDirExists checks if something exists at path, following symlinks.
+// Errors other than not-exist (e.g. permission denied) are returned rather than treated as missing
+func fileOrDirExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			return false
-		} else {
-			panic(fmt.Sprintf("Failed to stat path: %v", err))
+			return false, nil
 		}
+		return false, err
 	}
 
-	return true
+	return true, nil
+}
+
+// fileOrDirExistsOrExit is fileOrDirExists for commands that can't continue without an answer
+func fileOrDirExistsOrExit(path string) bool {
+	exists, err := fileOrDirExists(path)
+	if err != nil {
+		ExitWithMsg(1, fmt.Sprintf("Failed to check '%s': %v", path, err))
+	}
+	return exists
 }
 
 func isRelativePath(path string) bool {
 	return !filepath.IsAbs(path)
 }
 
-func profsOnPath(path string) []DetectedProfile {
-	_, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			slog.Warn(fmt.Sprintf("SrcPath does not exist: %v", path))
-			return []DetectedProfile{}
-		} else {
-			panic(fmt.Sprintf("Failed to stat path: %v", err))
-		}
-	}
-
+// profsOnPath lists the profile slots in a storage directory, returning an error wrapping
+// fs.ErrNotExist if the directory doesn't exist
+func profsOnPath(path string) ([]DetectedProfile, error) {
 	files, err := os.ReadDir(path)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to read dir: %v", err))
+		return []DetectedProfile{}, err
 	}
 
 	var items []DetectedProfile
 	for _, f := range files {
-		if f.IsDir() || f.Type().IsRegular() || isSymlinkE(f) {
+		if name, sealed := sealedProfileName(f.Name()); sealed && f.Type().IsRegular() {
+			items = append(items, DetectedProfile{Name: name, Path: filepath.Join(path, f.Name()), Sealed: true})
+			continue
+		}
+		if isReservedSlotName(f.Name()) {
+			continue // placeholders and tool metadata, never profiles
+		}
+		if f.IsDir() || f.Type().IsRegular() || f.Type()&os.ModeSymlink == os.ModeSymlink {
 			fullPath := filepath.Join(path, f.Name())
 			items = append(items, DetectedProfile{
 				Name: f.Name(),
@@ -76,51 +89,53 @@ func profsOnPath(path string) []DetectedProfile {
 		}
 	}
 
-	return items
+	return items, nil
+}
+
+// isReservedSlotName checks if an entry of a storage directory is one profs creates itself, rather than a profile
+func isReservedSlotName(name string) bool {
+	return name == emptySlotName || name == ".git" || name == slotModesFile || strings.HasPrefix(name, ".unseal-") ||
+		(strings.HasPrefix(name, ".") && (strings.HasSuffix(name, sealedSlotSuffix) || strings.HasSuffix(name, sealedSlotSuffix+".tmp")))
 }
 
 func pathsAreEqual(p1, p2 string) bool {
 	return filepath.Clean(p1) == filepath.Clean(p2)
 }
 
-func isSymlink(path string) bool {
+// isSymlink checks if path itself is a symlink, without following it
+func isSymlink(path string) (bool, error) {
 	fi, err := os.Lstat(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			slog.Warn(fmt.Sprintf("SrcPath does not exist: %v", path))
-			return false
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
 		}
-		panic(fmt.Sprintf("Failed to get file info: %v", err))
+		return false, err
 	}
 
-	return fi.Mode()&os.ModeSymlink == os.ModeSymlink
+	return fi.Mode()&os.ModeSymlink == os.ModeSymlink, nil
 }
 
-func isSymlinkE(f os.DirEntry) bool {
-	fi, err := f.Info()
+// isSymlinkOrExit is isSymlink for commands that can't continue without an answer
+func isSymlinkOrExit(path string) bool {
+	symlink, err := isSymlink(path)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to get file info: %v", err))
+		ExitWithMsg(1, fmt.Sprintf("Failed to check '%s': %v", path, err))
 	}
-	return fi.Mode()&os.ModeSymlink == os.ModeSymlink
+	return symlink
 }
 
-func symlinkTarget(path string) string {
+func symlinkTarget(path string) (string, error) {
 
 	fi, err := os.Lstat(path)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to get file info: %v", err))
+		return "", err
 	}
 
 	if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
-		panic(fmt.Sprintf("Not a symlink: %v", path))
+		return "", fmt.Errorf("not a symlink: %v", path)
 	}
 
-	target, err := os.Readlink(path)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to read symlink target: %v", err))
-	}
-
-	return target
+	return os.Readlink(path)
 }
 
 func HomeDir() string {
@@ -154,9 +169,80 @@ func askForConfirmation(prompt string) bool {
 	return strings.HasPrefix(response, "y")
 }
 
+// paramEnricherShort is boa.ParamEnricherShort, except that -o is reserved for the global --output flag
+var paramEnricherShort boa.ParamEnricher = func(alreadyProcessed []boa.Param, param boa.Param, paramFieldName string) error {
+	if param.GetShort() == "" && strings.HasPrefix(param.GetName(), "o") {
+		return nil
+	}
+	return boa.ParamEnricherShort(alreadyProcessed, param, paramFieldName)
+}
+
 var paramEnricherDefault = boa.ParamEnricherCombine(
 	boa.ParamEnricherName,
-	boa.ParamEnricherShort,
+	paramEnricherShort,
 	//ParamEnricherEnv,
 	boa.ParamEnricherBool,
 )
+
+// replaceSymlink points the symlink at linkPath to target, removing any existing symlink first
+func replaceSymlink(linkPath string, target string) error {
+	symlink, err := isSymlink(linkPath)
+	if err != nil {
+		return err
+	}
+	if symlink {
+		if err := os.Remove(linkPath); err != nil {
+			return fmt.Errorf("failed to remove existing symlink '%s': %w", linkPath, err)
+		}
+	}
+	if err := os.Symlink(target, linkPath); err != nil {
+		return fmt.Errorf("failed to create symlink '%s' -> '%s': %w", linkPath, target, err)
+	}
+	return nil
+}
+
+// rollback collects undo operations for multi-step filesystem changes,
+// so they can be reverted in reverse order if a later step fails
+type rollback []func() error
+
+func (r *rollback) add(undo func() error) {
+	*r = append(*r, undo)
+}
+
+func (r *rollback) run() []error {
+	var errs []error
+	for i := len(*r) - 1; i >= 0; i-- {
+		if err := (*r)[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	*r = nil
+	return errs
+}
+
+// exitAfterRollback reverts all recorded operations and exits with the given message,
+// appending any errors encountered while rolling back
+func exitAfterRollback(r *rollback, msg string) {
+	if len(*r) == 0 {
+		ExitWithMsg(1, msg)
+	}
+	errs := r.run()
+	if len(errs) > 0 {
+		msg += "\nWARNING: Rollback was incomplete:"
+		for _, err := range errs {
+			msg += "\n  " + err.Error()
+		}
+	} else {
+		msg += "\nAll changes have been rolled back."
+	}
+	ExitWithMsg(1, msg)
+}
+
+// toAbsPath expands a leading ~ and converts the path to an absolute path
+func toAbsPath(p string) (string, error) {
+	p = expandHomePath(p)
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p), nil
+	}
+	return filepath.Abs(p)
+}
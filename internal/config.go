@@ -2,19 +2,92 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 )
 
 type GlobalConfigRaw struct {
 	Paths []string `json:"paths"`
+	// StorageRoot, if set, is where storage for newly added paths is placed, instead of next to each path
+	StorageRoot string `json:"storageRoot,omitempty"`
+	// Storage maps managed paths to their storage directories, for paths not using the sibling <path>.profs directory
+	Storage map[string]string `json:"storage,omitempty"`
+	// Groups are named sets of paths that can be switched together, e.g. "cloud": ["~/.aws", "~/.kube"]
+	Groups map[string][]string `json:"groups,omitempty"`
+	// Overrides records paths intentionally switched to another profile than the rest, by a partial 'set'
+	Overrides map[string]string `json:"overrides,omitempty"`
+	// Mixes are named combinations of profiles per path, e.g. "oncall": {"ssh": "work", "~/.kube": "prod"}.
+	// Paths are given either as configured, or by their short name (base name without leading dots)
+	Mixes map[string]map[string]string `json:"mixes,omitempty"`
+	// MissingSlot is the policy per path for switching to a profile that has no slot for it,
+	// one of "keep" (default), "fail", "empty" or "fallback:<profile>". Paths are given as in Mixes
+	MissingSlot map[string]string `json:"missingSlot,omitempty"`
+	// Rules select a profile for git repositories by remote URL, first match wins
+	Rules []RemoteRule `json:"rules,omitempty"`
+	// Colors of profiles (or mixes) in 'profs prompt', e.g. "work": "blue". Supported colors are
+	// black, red, green, yellow, blue, magenta, cyan and white
+	Colors map[string]string `json:"colors,omitempty"`
+	// Sparse declares the paths a profile doesn't manage, e.g. "personal": ["~/.aws"]. No slots are
+	// created for them, 'profs set' leaves them on their current profile, and 'profs doctor' doesn't
+	// report them as missing. Paths are given as in Mixes
+	Sparse map[string][]string `json:"sparse,omitempty"`
+	// Encryption holds the keys used for encrypted archives
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+	// TrustedSigners are ed25519 public keys in authorized_keys format ("ssh-ed25519 AAAA..."), or .pub files
+	// holding them. If set, 'profs import' only accepts archives signed by one of them
+	TrustedSigners []string `json:"trustedSigners,omitempty"`
+	// Versioning makes each storage directory a git repository, committed to whenever a path is switched
+	// away from a profile, see 'profs history' and 'profs restore'
+	Versioning bool `json:"versioning,omitempty"`
+	// AutoSnapshot snapshots all profiles before 'profs remove-profile' and 'profs reset', see 'profs snapshot'
+	AutoSnapshot bool `json:"autoSnapshot,omitempty"`
+}
+
+// EncryptionConfig lists age keys. Without recipients, archives are encrypted with a passphrase instead
+type EncryptionConfig struct {
+	// Recipients are age public keys (age1...) that 'profs export --encrypt' encrypts to
+	Recipients []string `json:"recipients,omitempty"`
+	// Identities are files with age private keys that 'profs import' decrypts with, e.g. "~/.config/age/keys.txt"
+	Identities []string `json:"identities,omitempty"`
+	// SealInactive locks the slots a 'profs set' switches away from, see 'profs lock'. It can't be combined
+	// with Versioning, which would keep their plaintext in the history
+	SealInactive bool `json:"sealInactive,omitempty"`
+}
+
+// RemoteRule maps git remote URLs matching a pattern like "github.com/acme-corp/*" to a profile (or mix).
+// Remote URLs are normalized to host/path before matching, and * matches any sequence of characters
+type RemoteRule struct {
+	Remote  string `json:"remote"`
+	Profile string `json:"profile"`
 }
 
 type GlobalConfig struct {
-	Paths []Path
+	Paths          []Path
+	Groups         map[string][]string
+	Mixes          map[string]map[string]string
+	Rules          []RemoteRule
+	Colors         map[string]string
+	Sparse         map[string][]string
+	Encryption     EncryptionConfig
+	TrustedSigners []string
+	Versioning     bool
+	AutoSnapshot   bool
+}
+
+// MixEntry is a single path -> profile assignment of a mix
+type MixEntry struct {
+	Path    Path
+	Profile string
 }
 
 func (g GlobalConfig) DetectedProfileNames() []string {
@@ -35,26 +108,167 @@ func (g GlobalConfig) ActiveProfileNames() []string {
 	}))
 }
 
+// BaseProfileNames returns the active profiles of all paths that are not
+// intentionally switched to another profile by a partial 'set'
+func (g GlobalConfig) BaseProfileNames() []string {
+	return lo.Uniq(lo.FlatMap(g.Paths, func(p Path, _ int) []string {
+		if p.ResolvedTgt != nil && p.OverrideProfile == "" {
+			return []string{p.ResolvedTgt.Name}
+		} else {
+			return []string{}
+		}
+	}))
+}
+
+// OverriddenPaths returns the paths intentionally switched to another profile by a partial 'set'
+func (g GlobalConfig) OverriddenPaths() []Path {
+	return lo.Filter(g.Paths, func(p Path, _ int) bool {
+		return p.OverrideProfile != ""
+	})
+}
+
+// IsIntendedMixedState returns true if multiple profiles are active, but only because
+// of partial switches, i.e. all other paths share a single profile and all
+// overridden paths are on the profile they were switched to
+func (g GlobalConfig) IsIntendedMixedState() bool {
+	overridden := g.OverriddenPaths()
+	if len(overridden) == 0 || len(g.BaseProfileNames()) > 1 {
+		return false
+	}
+	return lo.EveryBy(overridden, func(p Path) bool {
+		return p.ResolvedTgt != nil && p.ResolvedTgt.Name == p.OverrideProfile
+	})
+}
+
+// PathsOfGroup returns the absolute paths of the named path group
+func (g GlobalConfig) PathsOfGroup(group string) ([]string, error) {
+	paths, found := g.Groups[group]
+	if !found {
+		return nil, fmt.Errorf("path group '%s' not found", group)
+	}
+	return lo.Map(paths, func(p string, _ int) string {
+		return expandHomePath(p)
+	}), nil
+}
+
+// IsSparse checks if a profile is declared as not managing a path
+func (g GlobalConfig) IsSparse(profile string, path Path) bool {
+	return isSparse(g.Sparse, profile, path.SrcPath)
+}
+
+func isSparse(sparse map[string][]string, profile string, absPath string) bool {
+	return lo.ContainsBy(sparse[profile], func(key string) bool { return pathMatchesKey(key, absPath) })
+}
+
+// IsLocked checks if any slot of a profile is sealed by 'profs lock'
+func (g GlobalConfig) IsLocked(profile string) bool {
+	return lo.SomeBy(g.Paths, func(p Path) bool {
+		return lo.ContainsBy(p.DetectedProfs, func(prof DetectedProfile) bool { return prof.Name == profile && prof.Sealed })
+	})
+}
+
+// MixNames returns the names of all mixes defined in the config, sorted
+func (g GlobalConfig) MixNames() []string {
+	names := lo.Keys(g.Mixes)
+	slices.Sort(names)
+	return names
+}
+
+// MixEntries resolves the paths of a mix to the managed paths they refer to
+func (g GlobalConfig) MixEntries(mix string) ([]MixEntry, error) {
+	assignments, found := g.Mixes[mix]
+	if !found {
+		return nil, fmt.Errorf("mix '%s' not found", mix)
+	}
+	var entries []MixEntry
+	for _, key := range slices.Sorted(maps.Keys(assignments)) {
+		path, found := lo.Find(g.Paths, func(p Path) bool { return pathMatchesKey(key, p.SrcPath) })
+		if !found {
+			return nil, fmt.Errorf("mix '%s' refers to path '%s', which is not managed by profs", mix, key)
+		}
+		entries = append(entries, MixEntry{Path: path, Profile: assignments[key]})
+	}
+	return entries, nil
+}
+
+// ActiveMixName returns the first mix (by name) that the current symlink state matches exactly, if any
+func (g GlobalConfig) ActiveMixName() (string, bool) {
+	for _, mix := range g.MixNames() {
+		entries, err := g.MixEntries(mix)
+		if err != nil || len(entries) == 0 {
+			continue
+		}
+		if lo.EveryBy(entries, func(e MixEntry) bool {
+			return e.Path.ResolvedTgt != nil && e.Path.ResolvedTgt.Name == e.Profile
+		}) {
+			return mix, true
+		}
+	}
+	return "", false
+}
+
+// ActiveState resolves what is active on the managed paths, as one of none, single, mix, mixed (intended,
+// after a partial 'set') or multiple (unintended), and the active profile, mix, or base profile of a mixed
+// state. A matching mix wins, so that a mix assigning every path to one profile is reported by its name
+func (g GlobalConfig) ActiveState() (string, string) {
+	if mix, found := g.ActiveMixName(); found {
+		return "mix", mix
+	}
+	active := g.ActiveProfileNames()
+	switch {
+	case len(active) == 0:
+		return "none", ""
+	case len(active) == 1:
+		return "single", active[0]
+	case g.IsIntendedMixedState():
+		return "mixed", lo.FirstOrEmpty(g.BaseProfileNames())
+	default:
+		return "multiple", ""
+	}
+}
+
+// pathMatchesKey checks if a path reference from the config, either a path or a short name
+// like "ssh" for ~/.ssh, refers to the given absolute path
+func pathMatchesKey(key string, absPath string) bool {
+	if strings.ContainsRune(key, filepath.Separator) || strings.HasPrefix(key, "~") {
+		return pathsAreEqual(expandHomePath(key), absPath)
+	}
+	return key == filepath.Base(absPath) || key == strings.TrimLeft(filepath.Base(absPath), ".")
+}
+
 func (g GlobalConfig) AllProfilesResolved() bool {
 	return lo.EveryBy(g.Paths, func(p Path) bool {
-		return p.Status == StatusOk
+		return p.Status == StatusOk || p.Status == StatusEmpty
 	})
 }
 
-func LoadGlobalConfRaw() GlobalConfigRaw {
+// ReadGlobalConfRaw reads and parses the global config file
+func ReadGlobalConfRaw() (GlobalConfigRaw, error) {
 
-	bytes, err := os.ReadFile(GlobalConfigPath())
+	configPath, err := globalConfigPath()
 	if err != nil {
-		panic(fmt.Sprintf("Failed to read global config file: %v", err))
+		return GlobalConfigRaw{}, err
+	}
+	bytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return GlobalConfigRaw{}, fmt.Errorf("failed to read global config file: %w", err)
 	}
 
 	gc := GlobalConfigRaw{}
 	err = json.Unmarshal(bytes, &gc)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to parse global config from file: %v", err))
+		return GlobalConfigRaw{}, fmt.Errorf("failed to parse global config from file %s: %w", configPath, err)
 	}
 
-	return gc
+	return gc, nil
+}
+
+func LoadGlobalConfRaw() GlobalConfigRaw {
+	gcr, err := ReadGlobalConfRaw()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load global config: %v", err))
+	}
+	return gcr
 }
 
 func SaveGlobalConfRaw(gcr GlobalConfigRaw) {
@@ -70,67 +284,257 @@ func SaveGlobalConfRaw(gcr GlobalConfigRaw) {
 }
 
 func LoadGlobalConf() GlobalConfig {
+	gc, err := ReadGlobalConf()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load global config: %v", err))
+	}
+	return gc
+}
+
+// ReadGlobalConf reads the global config and probes the state of every managed path
+func ReadGlobalConf() (GlobalConfig, error) {
+	if _, err := os.UserHomeDir(); err != nil {
+		return GlobalConfig{}, fmt.Errorf("failed to get home dir: %w", err)
+	}
+	gcr, err := ReadGlobalConfRaw()
+	if err != nil {
+		return GlobalConfig{}, err
+	}
+	if gcr.Versioning && gcr.Encryption != nil && gcr.Encryption.SealInactive {
+		return GlobalConfig{}, errors.New("versioning can't be combined with encryption.sealInactive, as the history would keep the locked slots in plaintext")
+	}
 
-	gcr := LoadGlobalConfRaw()
+	return GlobalConfig{
+		Groups:         gcr.Groups,
+		Mixes:          gcr.Mixes,
+		Rules:          gcr.Rules,
+		Colors:         gcr.Colors,
+		Sparse:         gcr.Sparse,
+		Encryption:     lo.FromPtr(gcr.Encryption),
+		TrustedSigners: gcr.TrustedSigners,
+		Versioning:     gcr.Versioning,
+		AutoSnapshot:   gcr.AutoSnapshot,
+		Paths:          gcr.probePaths(),
+	}, nil
+}
 
-	// convert any paths starting with ~ to absolute paths with home dir
-	for i, p := range gcr.Paths {
-		if strings.HasPrefix(p, "~") {
-			gcr.Paths[i] = filepath.Join(HomeDir(), p[1:])
+// probePaths inspects every managed path of the config, with paths starting with ~ relative to the home dir
+func (gcr GlobalConfigRaw) probePaths() []Path {
+	return lo.Map(gcr.Paths, func(p string, _ int) Path {
+		srcPath := expandHomePath(p)
+		path := probePath(srcPath, gcr.StorageDirFor(srcPath))
+		path.OverrideProfile = gcr.overrideFor(srcPath)
+		path.MissingSlot = gcr.missingSlotFor(srcPath)
+		return path
+	})
+}
+
+// readGlobalConfPaths reads what it can of the managed paths from a config that fails to load, for
+// diagnosing it. Only the settings locating the paths and their storage need to be valid
+func readGlobalConfPaths() ([]Path, error) {
+	configPath, err := globalConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bytes, &fields); err != nil {
+		return nil, err
+	}
+	gcr := GlobalConfigRaw{}
+	if raw, found := fields["paths"]; found {
+		if err := json.Unmarshal(raw, &gcr.Paths); err != nil {
+			return nil, err
 		}
 	}
+	// storage settings that don't parse are left out, so those paths are looked for next to themselves
+	_ = json.Unmarshal(fields["storageRoot"], &gcr.StorageRoot)
+	_ = json.Unmarshal(fields["storage"], &gcr.Storage)
+	return gcr.probePaths(), nil
+}
 
-	return GlobalConfig{
-		Paths: lo.Map(gcr.Paths, func(p string, _ int) Path {
-			symSrcPath := func() string {
-				if strings.HasPrefix(p, "~") {
-					return filepath.Join(HomeDir(), p[1:])
-				} else {
-					return p
-				}
-			}()
-			detectedProfs := profsOnPath(symSrcPath + ".profs")
-			var status Status
-			var resolvedTgt *DetectedProfile = nil
-			var target *string = nil
-
-			if !fileOrDirExists(symSrcPath) {
-				status = StatusErrorSrcNotFound
-			} else if isSymlink(symSrcPath) {
-				symLinKT := symlinkTarget(symSrcPath)
-				target = &symLinKT
-				if isRelativePath(symLinKT) {
-					symLinKT = filepath.Join(filepath.Dir(symSrcPath), symLinKT)
-				}
-
-				if !fileOrDirExists(symLinKT) {
-					status = StatusErrorTgtNotFound
-				} else {
-
-					_, i, found := lo.FindIndexOf(detectedProfs, func(prof DetectedProfile) bool {
-						return pathsAreEqual(prof.Path, symLinKT)
-					})
-
-					if found {
-						status = StatusOk
-						resolvedTgt = &detectedProfs[i]
-					} else {
-						status = StatusErrorTgtUnresolvable
-					}
-				}
-			} else {
-				status = StatusErrorSrcNotSymlink
-			}
+// loadGlobalConfRawOrExit reads the global config for a command about to change it,
+// exiting with ExitConfig if it can't be loaded
+func loadGlobalConfRawOrExit() GlobalConfigRaw {
+	gcr, err := ReadGlobalConfRaw()
+	if err != nil {
+		exitWithConfigError(err)
+	}
+	return gcr
+}
 
-			return Path{
-				SrcPath:       symSrcPath,
-				DetectedProfs: detectedProfs,
-				TgtPath:       target,
-				ResolvedTgt:   resolvedTgt,
-				Status:        status,
-			}
-		}),
+// exitWithConfigError exits with ExitConfig, pointing to the commands that still work with a broken config
+func exitWithConfigError(err error) {
+	ExitWithMsg(ExitConfig, fmt.Sprintf("%v\nRun 'profs doctor' or 'profs status-config' to diagnose, or 'profs reset' to start over", err))
+}
+
+// probePath inspects a managed path and its storage directory. Filesystem errors never abort,
+// they are reported through the status of the path, so one unreadable path doesn't break every command
+func probePath(srcPath string, storageDir string) Path {
+	path := Path{
+		SrcPath:       srcPath,
+		StorageDir:    storageDir,
+		DetectedProfs: []DetectedProfile{},
+	}
+	fail := func(status Status, err error) Path {
+		path.Status = status
+		if err != nil {
+			path.Error = err.Error()
+		}
+		return path
+	}
+
+	detectedProfs, err := profsOnPath(storageDir)
+	storageMissing := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !storageMissing {
+		return fail(statusOfError(err), err)
+	}
+	path.DetectedProfs = detectedProfs
+
+	srcExists, err := fileOrDirExists(srcPath)
+	symlink, lerr := isSymlink(srcPath)
+	if lerr != nil {
+		return fail(statusOfError(lerr), lerr)
+	}
+	if err != nil && !symlink {
+		return fail(statusOfError(err), err)
+	}
+	if !srcExists && !symlink {
+		return fail(StatusErrorSrcNotFound, nil)
+	}
+	if !symlink {
+		return fail(StatusErrorSrcNotSymlink, nil)
+	}
+
+	target, err := symlinkTarget(srcPath)
+	if err != nil {
+		return fail(statusOfError(err), err)
+	}
+	path.TgtPath = &target
+	absTarget := target
+	if isRelativePath(absTarget) {
+		absTarget = filepath.Join(filepath.Dir(srcPath), absTarget)
+	}
+
+	if storageMissing {
+		return fail(StatusErrorStorageNotFound, nil)
+	}
+
+	// the symlink itself is fine, but following it failed, e.g. a loop or an unreadable target
+	tgtExists, err := fileOrDirExists(absTarget)
+	if err != nil {
+		return fail(statusOfError(err), err)
+	}
+	if !tgtExists {
+		return fail(StatusErrorTgtNotFound, nil)
+	}
+
+	if pathsAreEqual(absTarget, filepath.Join(storageDir, emptySlotName)) {
+		return fail(StatusEmpty, nil)
+	}
+
+	_, i, found := lo.FindIndexOf(detectedProfs, func(prof DetectedProfile) bool {
+		return pathsAreEqual(prof.Path, absTarget)
+	})
+	if !found {
+		return fail(StatusErrorTgtUnresolvable, nil)
+	}
+	path.ResolvedTgt = &detectedProfs[i]
+
+	if err := checkSlotType(detectedProfs, i); err != nil {
+		return fail(StatusErrorWrongSlotType, err)
+	}
+
+	path.Status = StatusOk
+	return path
+}
+
+// checkSlotType verifies that the slot at index i is a file or directory like the other slots of the path,
+// since a program expecting a config file can't use a directory and vice versa
+func checkSlotType(slots []DetectedProfile, i int) error {
+	fi, err := os.Stat(slots[i].Path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() && !fi.Mode().IsRegular() {
+		return fmt.Errorf("slot '%s' is neither a file nor a directory", slots[i].Path)
+	}
+	for j, other := range slots {
+		if j == i || other.Sealed {
+			continue
+		}
+		ofi, err := os.Stat(other.Path)
+		if err != nil {
+			continue // problems with other slots surface when switching to them
+		}
+		if ofi.IsDir() != fi.IsDir() {
+			return fmt.Errorf("slot '%s' is a %s, but slot '%s' is a %s", slots[i].Path, fileKind(fi), other.Path, fileKind(ofi))
+		}
+	}
+	return nil
+}
+
+func fileKind(fi os.FileInfo) string {
+	if fi.IsDir() {
+		return "directory"
+	}
+	return "file"
+}
+
+// statusOfError maps a filesystem error to the status describing it best
+func statusOfError(err error) Status {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return StatusErrorPermissionDenied
+	case errors.Is(err, syscall.ELOOP):
+		return StatusErrorSymlinkLoop
+	case errors.Is(err, fs.ErrNotExist):
+		return StatusErrorSrcNotFound
+	default:
+		return StatusErrorIO
+	}
+}
+
+// LazyGlobalConfig loads the global config on first use, so that commands not needing
+// it, as well as --help and shell completion, keep working when the config is broken
+type LazyGlobalConfig struct {
+	once sync.Once
+	gc   GlobalConfig
+	err  error
+}
+
+func NewLazyGlobalConfig() *LazyGlobalConfig {
+	return &LazyGlobalConfig{}
+}
+
+// Load returns the global config, loading it on first call
+func (l *LazyGlobalConfig) Load() (GlobalConfig, error) {
+	l.once.Do(func() {
+		l.gc, l.err = ReadGlobalConf()
+	})
+	return l.gc, l.err
+}
+
+// Get returns the global config, exiting with an error message if it can't be loaded
+func (l *LazyGlobalConfig) Get() GlobalConfig {
+	gc, err := l.Load()
+	if err != nil {
+		exitWithConfigError(err)
 	}
+	return gc
+}
+
+// completeProfiles is a shell completion function for profile names, which silently completes
+// nothing if the config can't be loaded
+func (l *LazyGlobalConfig) completeProfiles(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	gc, err := l.Load()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return gc.DetectedProfileNames(), cobra.ShellCompDirectiveNoFileComp
 }
 
 func LegacyConfigDirPath() string {
@@ -142,27 +546,48 @@ func ConfigDirPath() string {
 }
 
 func ConfigDir() string {
+	path, err := configDir()
+	if err != nil {
+		panic(err.Error())
+	}
+	return path
+}
+
+func configDir() (string, error) {
 	path := ConfigDirPath()
-	if fileOrDirExists(path) {
-		return path
+	if exists, err := fileOrDirExists(path); err != nil || exists {
+		return path, err
 	}
 
 	legacyPath := LegacyConfigDirPath()
-	if fileOrDirExists(legacyPath) {
-		return legacyPath
+	if exists, err := fileOrDirExists(legacyPath); err != nil || exists {
+		return legacyPath, err
 	}
 
 	err := os.MkdirAll(path, os.ModePerm)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to read or create config dir: %v", err))
+		return "", fmt.Errorf("failed to read or create config dir: %w", err)
 	}
-	return path
+	return path, nil
 }
 
 func GlobalConfigPath() string {
-	filePath := filepath.Join(ConfigDir(), "global.json")
+	filePath, err := globalConfigPath()
+	if err != nil {
+		panic(err.Error())
+	}
+	return filePath
+}
+
+// globalConfigPath returns the path of the global config file, creating a blank one if it doesn't exist
+func globalConfigPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(dir, "global.json")
 	if TestMode {
-		filePath = filepath.Join(ConfigDir(), "global.test.json")
+		filePath = filepath.Join(dir, "global.test.json")
 	}
 	// check that file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -170,12 +595,312 @@ func GlobalConfigPath() string {
 		blankConfig := GlobalConfigRaw{}
 		jsBytes, err := json.MarshalIndent(blankConfig, "", "  ")
 		if err != nil {
-			panic(fmt.Sprintf("Failed to marshal blank config to json: %v", err))
+			return "", fmt.Errorf("failed to marshal blank config to json: %w", err)
 		}
 		err = os.WriteFile(filePath, jsBytes, os.ModePerm)
 		if err != nil {
-			panic(fmt.Sprintf("Failed to write blank config to file: %v", err))
+			return "", fmt.Errorf("failed to write blank config to file: %w", err)
 		}
 	}
-	return filePath
+	return filePath, nil
+}
+
+// expandHomePath converts a path as stored in the config (possibly starting with ~) to an absolute path
+func expandHomePath(p string) string {
+	if strings.HasPrefix(p, "~") {
+		return filepath.Join(HomeDir(), p[1:])
+	}
+	return p
+}
+
+// toConfigPath converts an absolute path to the form stored in the config, using ~ for the home directory
+func toConfigPath(p string) string {
+	homeDir := HomeDir()
+	if p == homeDir || strings.HasPrefix(p, homeDir+string(filepath.Separator)) {
+		return "~" + p[len(homeDir):]
+	}
+	return p
+}
+
+// findConfigPathIndex returns the index of the configured path matching the given absolute path, or -1
+func (gcr GlobalConfigRaw) findConfigPathIndex(absPath string) int {
+	_, i, found := lo.FindIndexOf(gcr.Paths, func(p string) bool {
+		return pathsAreEqual(expandHomePath(p), absPath)
+	})
+	if !found {
+		return -1
+	}
+	return i
+}
+
+// overrideFor returns the profile a path was intentionally switched to by a partial 'set', if any
+func (gcr GlobalConfigRaw) overrideFor(absPath string) string {
+	for p, profile := range gcr.Overrides {
+		if pathsAreEqual(expandHomePath(p), absPath) {
+			return profile
+		}
+	}
+	return ""
+}
+
+// missingSlotFor returns the missing slot policy configured for a path, if any
+func (gcr GlobalConfigRaw) missingSlotFor(absPath string) string {
+	for key, policy := range gcr.MissingSlot {
+		if pathMatchesKey(key, absPath) {
+			return policy
+		}
+	}
+	return ""
+}
+
+// setOverride records (or with an empty profile, clears) a partial switch of a path
+func (gcr *GlobalConfigRaw) setOverride(absPath string, profile string) {
+	for p := range gcr.Overrides {
+		if pathsAreEqual(expandHomePath(p), absPath) {
+			delete(gcr.Overrides, p)
+		}
+	}
+	if profile != "" {
+		if gcr.Overrides == nil {
+			gcr.Overrides = map[string]string{}
+		}
+		gcr.Overrides[toConfigPath(absPath)] = profile
+	}
+	if len(gcr.Overrides) == 0 {
+		gcr.Overrides = nil
+	}
+}
+
+func (gcr GlobalConfigRaw) isSparse(profile string, absPath string) bool {
+	return isSparse(gcr.Sparse, profile, absPath)
+}
+
+// setSparse records whether a profile is declared as not managing a path
+func (gcr *GlobalConfigRaw) setSparse(profile string, absPath string, sparse bool) {
+	paths := lo.Reject(gcr.Sparse[profile], func(key string, _ int) bool { return pathMatchesKey(key, absPath) })
+	if sparse {
+		paths = append(paths, toConfigPath(absPath))
+	}
+	if len(paths) > 0 {
+		if gcr.Sparse == nil {
+			gcr.Sparse = map[string][]string{}
+		}
+		gcr.Sparse[profile] = paths
+	} else {
+		delete(gcr.Sparse, profile)
+	}
+	if len(gcr.Sparse) == 0 {
+		gcr.Sparse = nil
+	}
+}
+
+// removePathRefs removes all references to a path that is no longer managed
+func (gcr *GlobalConfigRaw) removePathRefs(absPath string) {
+	gcr.setOverride(absPath, "")
+	for profile := range gcr.Sparse {
+		gcr.setSparse(profile, absPath, false)
+	}
+}
+
+// renameProfileRefs updates all references to a profile name in the config
+func (gcr *GlobalConfigRaw) renameProfileRefs(from string, to string) {
+	for p, profile := range gcr.Overrides {
+		if profile == from {
+			gcr.Overrides[p] = to
+		}
+	}
+	for _, assignments := range gcr.Mixes {
+		for p, profile := range assignments {
+			if profile == from {
+				assignments[p] = to
+			}
+		}
+	}
+	if color, found := gcr.Colors[from]; found {
+		delete(gcr.Colors, from)
+		gcr.Colors[to] = color
+	}
+	for i, rule := range gcr.Rules {
+		if rule.Profile == from {
+			gcr.Rules[i].Profile = to
+		}
+	}
+	for p, policy := range gcr.MissingSlot {
+		if policy == string(MissingSlotFallback)+":"+from {
+			gcr.MissingSlot[p] = string(MissingSlotFallback) + ":" + to
+		}
+	}
+	if paths, found := gcr.Sparse[from]; found {
+		delete(gcr.Sparse, from)
+		gcr.Sparse[to] = paths
+	}
+}
+
+// ProfileRefs are the config entries referring to a profile, removed together with it by 'profs remove-profile'
+type ProfileRefs struct {
+	Overrides   map[string]string            `json:"overrides,omitempty"`
+	Mixes       map[string]map[string]string `json:"mixes,omitempty"`
+	MissingSlot map[string]string            `json:"missingSlot,omitempty"`
+	// Rules are kept with their position, so they are restored with the same precedence
+	Rules []IndexedRule `json:"rules,omitempty"`
+	Color string        `json:"color,omitempty"`
+}
+
+type IndexedRule struct {
+	Index int `json:"index"`
+	RemoteRule
+}
+
+func (r ProfileRefs) IsEmpty() bool {
+	return len(r.Overrides) == 0 && len(r.Mixes) == 0 && len(r.MissingSlot) == 0 && len(r.Rules) == 0 && r.Color == ""
+}
+
+// Describe lists the removed entries for the user, e.g. "mix 'oncall', color"
+func (r ProfileRefs) Describe() []string {
+	var result []string
+	for _, mix := range lo.Keys(r.Mixes) {
+		result = append(result, fmt.Sprintf("mix '%s'", mix))
+	}
+	for _, rule := range r.Rules {
+		result = append(result, fmt.Sprintf("rule '%s'", rule.Remote))
+	}
+	for _, p := range lo.Keys(r.Overrides) {
+		result = append(result, fmt.Sprintf("override of '%s'", p))
+	}
+	for _, p := range lo.Keys(r.MissingSlot) {
+		result = append(result, fmt.Sprintf("missingSlot fallback of '%s'", p))
+	}
+	if r.Color != "" {
+		result = append(result, "color")
+	}
+	slices.Sort(result)
+	return result
+}
+
+// removeProfileRefs removes all references to a removed profile from the config, returning them.
+// Mixes assigning the profile to any path are removed as a whole, as they can no longer be set
+func (gcr *GlobalConfigRaw) removeProfileRefs(profile string) ProfileRefs {
+	refs := ProfileRefs{}
+	for p, o := range gcr.Overrides {
+		if o == profile {
+			refs.Overrides = lo.Assign(refs.Overrides, map[string]string{p: o})
+			delete(gcr.Overrides, p)
+		}
+	}
+	for mix, assignments := range gcr.Mixes {
+		if lo.Contains(lo.Values(assignments), profile) {
+			refs.Mixes = lo.Assign(refs.Mixes, map[string]map[string]string{mix: assignments})
+			delete(gcr.Mixes, mix)
+		}
+	}
+	for p, policy := range gcr.MissingSlot {
+		if policy == string(MissingSlotFallback)+":"+profile {
+			refs.MissingSlot = lo.Assign(refs.MissingSlot, map[string]string{p: policy})
+			delete(gcr.MissingSlot, p)
+		}
+	}
+	kept := []RemoteRule{}
+	for i, rule := range gcr.Rules {
+		if rule.Profile == profile {
+			refs.Rules = append(refs.Rules, IndexedRule{Index: i, RemoteRule: rule})
+		} else {
+			kept = append(kept, rule)
+		}
+	}
+	if len(refs.Rules) > 0 {
+		gcr.Rules = kept
+	}
+	if color, found := gcr.Colors[profile]; found {
+		refs.Color = color
+		delete(gcr.Colors, profile)
+	}
+	gcr.dropEmptyRefs()
+	return refs
+}
+
+// restoreProfileRefs puts back the references removed by removeProfileRefs, without overwriting
+// entries configured since
+func (gcr *GlobalConfigRaw) restoreProfileRefs(refs ProfileRefs, profile string) {
+	for p, o := range refs.Overrides {
+		if gcr.overrideFor(expandHomePath(p)) == "" {
+			gcr.Overrides = lo.Assign(gcr.Overrides, map[string]string{p: o})
+		}
+	}
+	for mix, assignments := range refs.Mixes {
+		if _, exists := gcr.Mixes[mix]; !exists {
+			gcr.Mixes = lo.Assign(gcr.Mixes, map[string]map[string]string{mix: assignments})
+		}
+	}
+	for p, policy := range refs.MissingSlot {
+		if _, exists := gcr.MissingSlot[p]; !exists {
+			gcr.MissingSlot = lo.Assign(gcr.MissingSlot, map[string]string{p: policy})
+		}
+	}
+	for _, rule := range refs.Rules {
+		if lo.Contains(gcr.Rules, rule.RemoteRule) {
+			continue
+		}
+		i := min(rule.Index, len(gcr.Rules))
+		gcr.Rules = append(gcr.Rules[:i], append([]RemoteRule{rule.RemoteRule}, gcr.Rules[i:]...)...)
+	}
+	if _, exists := gcr.Colors[profile]; refs.Color != "" && !exists {
+		gcr.Colors = lo.Assign(gcr.Colors, map[string]string{profile: refs.Color})
+	}
+}
+
+// dropEmptyRefs omits maps emptied by removals from the saved config
+func (gcr *GlobalConfigRaw) dropEmptyRefs() {
+	if len(gcr.Overrides) == 0 {
+		gcr.Overrides = nil
+	}
+	if len(gcr.Mixes) == 0 {
+		gcr.Mixes = nil
+	}
+	if len(gcr.MissingSlot) == 0 {
+		gcr.MissingSlot = nil
+	}
+	if len(gcr.Rules) == 0 {
+		gcr.Rules = nil
+	}
+	if len(gcr.Colors) == 0 {
+		gcr.Colors = nil
+	}
+}
+
+// renamePathRefs updates all references to a managed path in the config, except its storage location
+func (gcr *GlobalConfigRaw) renamePathRefs(fromAbs string, toAbs string) {
+	if profile := gcr.overrideFor(fromAbs); profile != "" {
+		gcr.setOverride(fromAbs, "")
+		gcr.setOverride(toAbs, profile)
+	}
+	for _, assignments := range gcr.Mixes {
+		for key, profile := range assignments {
+			if pathMatchesKey(key, fromAbs) {
+				delete(assignments, key)
+				assignments[toConfigPath(toAbs)] = profile
+			}
+		}
+	}
+	for key, policy := range gcr.MissingSlot {
+		if pathMatchesKey(key, fromAbs) {
+			delete(gcr.MissingSlot, key)
+			gcr.MissingSlot[toConfigPath(toAbs)] = policy
+		}
+	}
+	for group, paths := range gcr.Groups {
+		gcr.Groups[group] = lo.Map(paths, func(p string, _ int) string {
+			if pathsAreEqual(expandHomePath(p), fromAbs) {
+				return toConfigPath(toAbs)
+			}
+			return p
+		})
+	}
+	for profile, paths := range gcr.Sparse {
+		gcr.Sparse[profile] = lo.Map(paths, func(key string, _ int) string {
+			if pathMatchesKey(key, fromAbs) {
+				return toConfigPath(toAbs)
+			}
+			return key
+		})
+	}
 }
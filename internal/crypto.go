@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+	"golang.org/x/term"
+)
+
+// passphraseEnvVar, if set, is used instead of prompting for a passphrase, e.g. in scripts
+const passphraseEnvVar = "PROFS_PASSPHRASE"
+
+// ageHeaderPrefix starts every age encrypted file
+const ageHeaderPrefix = "age-encryption.org/"
+
+// readPassphrase reads a passphrase from PROFS_PASSPHRASE, or prompts for it on the terminal
+func readPassphrase(prompt string, confirm bool) (string, error) {
+	if passphrase, found := os.LookupEnv(passphraseEnvVar); found {
+		if passphrase == "" {
+			return "", fmt.Errorf("%s is empty", passphraseEnvVar)
+		}
+		return passphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("cannot prompt for a passphrase without a terminal, set %s instead", passphraseEnvVar)
+	}
+	read := func(prompt string) (string, error) {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: ", prompt)
+		bytes, err := term.ReadPassword(fd)
+		_, _ = fmt.Fprintln(os.Stderr)
+		return string(bytes), err
+	}
+
+	passphrase, err := read(prompt)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("the passphrase must not be empty")
+	}
+	if confirm {
+		again, err := read("Confirm " + prompt)
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("the passphrases don't match")
+		}
+	}
+	return passphrase, nil
+}
+
+// keyring provides the age keys of a command, reading them when first needed, so that a
+// passphrase is asked for at most once per command
+type keyring struct {
+	enc EncryptionConfig
+	// usePassphrase encrypts with a passphrase, even if recipients are configured
+	usePassphrase bool
+	passphrase    string
+	recipients    []age.Recipient
+	identities    []age.Identity
+}
+
+func newKeyring(enc EncryptionConfig, usePassphrase bool) *keyring {
+	return &keyring{enc: enc, usePassphrase: usePassphrase}
+}
+
+func (k *keyring) getPassphrase(confirm bool) (string, error) {
+	if k.passphrase == "" {
+		passphrase, err := readPassphrase("Passphrase", confirm)
+		if err != nil {
+			return "", err
+		}
+		k.passphrase = passphrase
+	}
+	return k.passphrase, nil
+}
+
+// Recipients returns the configured age recipients, or with none configured or usePassphrase
+// set, a recipient encrypting with a passphrase
+func (k *keyring) Recipients() ([]age.Recipient, error) {
+	if k.recipients != nil {
+		return k.recipients, nil
+	}
+
+	if k.usePassphrase || len(k.enc.Recipients) == 0 {
+		passphrase, err := k.getPassphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		k.recipients = []age.Recipient{recipient}
+		return k.recipients, nil
+	}
+
+	for _, key := range k.enc.Recipients {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient '%s' in config: %w", key, err)
+		}
+		k.recipients = append(k.recipients, recipient)
+	}
+	return k.recipients, nil
+}
+
+// Identities returns the identities of the configured identity files, and one asking for a
+// passphrase, for files encrypted with one
+func (k *keyring) Identities() ([]age.Identity, error) {
+	if k.identities != nil {
+		return k.identities, nil
+	}
+
+	identities := []age.Identity{passphraseIdentity{keys: k}}
+	for _, file := range k.enc.Identities {
+		f, err := os.Open(expandHomePath(file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		parsed, err := age.ParseIdentities(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse identity file '%s': %w", file, err)
+		}
+		identities = append(identities, parsed...)
+	}
+	k.identities = identities
+	return k.identities, nil
+}
+
+// passphraseIdentity only asks for a passphrase when the file is encrypted with one
+type passphraseIdentity struct {
+	keys *keyring
+}
+
+func (i passphraseIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	if len(stanzas) != 1 || stanzas[0].Type != "scrypt" {
+		return nil, age.ErrIncorrectIdentity
+	}
+	passphrase, err := i.keys.getPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return identity.Unwrap(stanzas)
+}
+
+// decrypt returns the decrypted content of an age encrypted reader
+func (k *keyring) decrypt(r io.Reader) (io.Reader, error) {
+	identities, err := k.Identities()
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return decrypted, nil
+}
+
+// decryptIfEncrypted returns the decrypted content of r if it is age encrypted, or r itself otherwise
+func decryptIfEncrypted(r io.Reader, keys *keyring) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	if header, _ := br.Peek(len(ageHeaderPrefix)); string(header) != ageHeaderPrefix {
+		return br, false, nil
+	}
+	decrypted, err := keys.decrypt(br)
+	return decrypted, true, err
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// fsOps performs the changes made by mutating commands, so that with --dry-run they
+// can be printed instead of performed
+type fsOps interface {
+	MkdirAll(path string, perm os.FileMode) error
+	// Rename moves a file or directory, also across filesystems
+	Rename(from string, to string) error
+	Symlink(target string, link string) error
+	Remove(path string) error
+	RemoveAll(path string) error
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// CopyDir copies the directory src to dst, which must not exist
+	CopyDir(src string, dst string) error
+	// Seal encrypts a slot into a sealed file, removing the slot, and Unseal does the opposite
+	Seal(slot string, sealed string, keys *keyring) error
+	Unseal(sealed string, slot string, keys *keyring) error
+	Chmod(path string, mode os.FileMode) error
+	// Snapshot records all slots and the config, see 'profs snapshot'
+	Snapshot(gc GlobalConfig, message string) error
+	// Git runs a git command changing the repository of a versioned storage directory
+	Git(dir string, args ...string) error
+	SaveConfig(gcr GlobalConfigRaw)
+	// RefreshState updates the state file after all changes have been made
+	RefreshState()
+}
+
+// newFsOps returns the operations to use, depending on the --dry-run flag of a command
+func newFsOps(dryRun bool) fsOps {
+	if dryRun {
+		infof("Dry run, nothing will be changed. Planned operations:\n")
+		return dryRunOps{}
+	}
+	return osOps{}
+}
+
+type osOps struct{}
+
+func (osOps) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (osOps) Rename(from string, to string) error          { return moveFileOrDir(from, to) }
+func (osOps) Symlink(target string, link string) error     { return os.Symlink(target, link) }
+func (osOps) Remove(path string) error                     { return os.Remove(path) }
+func (osOps) RemoveAll(path string) error                  { return removeAllWritable(path) }
+func (osOps) Chmod(path string, mode os.FileMode) error    { return os.Chmod(path, mode) }
+func (osOps) CopyDir(src string, dst string) error         { return os.CopyFS(dst, os.DirFS(src)) }
+func (osOps) SaveConfig(gcr GlobalConfigRaw)               { SaveGlobalConfRaw(gcr) }
+func (osOps) RefreshState()                                { refreshState() }
+
+func (osOps) Seal(slot string, sealed string, keys *keyring) error {
+	return sealSlot(slot, sealed, keys)
+}
+
+func (osOps) Unseal(sealed string, slot string, keys *keyring) error {
+	return unsealSlot(sealed, slot, keys)
+}
+
+func (osOps) Snapshot(gc GlobalConfig, message string) error {
+	snapshot, err := createSnapshot(gc, message, time.Now())
+	if err == nil {
+		infof("Created snapshot %s, restore it with 'profs snapshot restore %s'\n", snapshot.ID, snapshot.ID)
+	}
+	return err
+}
+
+func (osOps) Git(dir string, args ...string) error {
+	_, err := runGit(dir, args...)
+	return err
+}
+
+func (osOps) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+
+// dryRunOps prints each operation as the equivalent shell command
+type dryRunOps struct{}
+
+func (dryRunOps) plan(format string, args ...any) error {
+	infof("  "+format+"\n", args...)
+	return nil
+}
+
+func (d dryRunOps) MkdirAll(path string, _ os.FileMode) error {
+	return d.plan("mkdir -p %s", path)
+}
+
+func (d dryRunOps) Rename(from string, to string) error {
+	return d.plan("mv %s %s", from, to)
+}
+
+func (d dryRunOps) Symlink(target string, link string) error {
+	return d.plan("ln -s %s %s", target, link)
+}
+
+func (d dryRunOps) Remove(path string) error {
+	return d.plan("rm %s", path)
+}
+
+func (d dryRunOps) RemoveAll(path string) error {
+	return d.plan("rm -rf %s", path)
+}
+
+func (d dryRunOps) CopyDir(src string, dst string) error {
+	return d.plan("cp -r %s %s", src, dst)
+}
+
+func (d dryRunOps) Seal(slot string, sealed string, _ *keyring) error {
+	return d.plan("seal %s %s", slot, sealed)
+}
+
+func (d dryRunOps) Unseal(sealed string, slot string, _ *keyring) error {
+	return d.plan("unseal %s %s", sealed, slot)
+}
+
+func (d dryRunOps) Chmod(path string, mode os.FileMode) error {
+	return d.plan("chmod %o %s", mode, path)
+}
+
+func (d dryRunOps) Snapshot(_ GlobalConfig, message string) error {
+	return d.plan("snapshot %s (%s)", SnapshotsDir(), message)
+}
+
+func (d dryRunOps) Git(dir string, args ...string) error {
+	quoted := lo.Map(args, func(arg string, _ int) string {
+		if strings.ContainsAny(arg, " \t'\"") {
+			return strconv.Quote(arg)
+		}
+		return arg
+	})
+	return d.plan("git -C %s %s", dir, strings.Join(quoted, " "))
+}
+
+func (d dryRunOps) SaveConfig(_ GlobalConfigRaw) {
+	_ = d.plan("write %s", GlobalConfigPath())
+}
+
+// RefreshState does nothing, the state file reflects what is on disk
+func (dryRunOps) RefreshState() {}
+
+func (d dryRunOps) WriteFile(path string, data []byte, _ os.FileMode) error {
+	return d.plan("write %s (%s)", path, formatSize(len(data)))
+}
+
+func formatSize(n int) string {
+	if n == 1 {
+		return "1 byte"
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
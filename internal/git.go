@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type gitRemote struct {
+	Name string
+	URL  string
+}
+
+// findGitDir walks from dir up to the filesystem root, returning the root of the nearest
+// git working tree and its git directory (following .git files used by worktrees and submodules)
+func findGitDir(dir string) (string, string, bool, error) {
+	dir, err := toAbsPath(dir)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		stat, err := os.Stat(dotGit)
+		if err == nil {
+			if stat.IsDir() {
+				return dir, dotGit, true, nil
+			}
+			gitDir, err := readGitDirFile(dotGit)
+			if err != nil {
+				return "", "", false, err
+			}
+			return dir, gitDir, true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", "", false, err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", false, nil
+		}
+		dir = parent
+	}
+}
+
+// gitHooksDir returns the directory git reads the hooks of the repository enclosing dir from, which is
+// core.hooksPath if set, and otherwise shared by all worktrees of the repository
+func gitHooksDir(dir string) (string, error) {
+	hooksDir, err := runGit(dir, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(dir, hooksDir)
+	}
+	return filepath.Clean(hooksDir), nil
+}
+
+// readGitDirFile resolves a "gitdir: <path>" .git file
+func readGitDirFile(dotGitFile string) (string, error) {
+	bytes, err := os.ReadFile(dotGitFile)
+	if err != nil {
+		return "", err
+	}
+	gitDir := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(bytes)), "gitdir:"))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(dotGitFile), gitDir)
+	}
+	return gitDir, nil
+}
+
+// readGitRemotes returns the remotes configured in a git directory, with "origin" first
+func readGitRemotes(gitDir string) ([]gitRemote, error) {
+	// worktrees keep their config in the common git directory
+	configDir := gitDir
+	if commonDir, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		configDir = strings.TrimSpace(string(commonDir))
+		if !filepath.IsAbs(configDir) {
+			configDir = filepath.Join(gitDir, configDir)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(configDir, "config"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var remotes []gitRemote
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		name, found := strings.CutPrefix(section, "remote ")
+		if !found {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "url") {
+			continue
+		}
+		remotes = append(remotes, gitRemote{
+			Name: strings.Trim(strings.TrimSpace(name), `"`),
+			URL:  strings.Trim(strings.TrimSpace(value), `"`),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var sorted []gitRemote
+	for _, r := range remotes {
+		if r.Name == "origin" {
+			sorted = append(sorted, r)
+		}
+	}
+	for _, r := range remotes {
+		if r.Name != "origin" {
+			sorted = append(sorted, r)
+		}
+	}
+	return sorted, nil
+}
+
+// normalizeRemoteURL reduces the different forms of git remote URLs to host/path, e.g.
+// https://github.com/acme-corp/repo.git and git@github.com:acme-corp/repo.git both become github.com/acme-corp/repo
+func normalizeRemoteURL(remote string) string {
+	remote = strings.TrimSpace(remote)
+	if strings.Contains(remote, "://") {
+		if u, err := url.Parse(remote); err == nil {
+			remote = u.Hostname() + "/" + strings.TrimPrefix(u.Path, "/")
+		}
+	} else if host, path, found := strings.Cut(remote, ":"); found && !strings.Contains(host, "/") {
+		// scp-like syntax: [user@]host:path
+		if _, afterAt, hasUser := strings.Cut(host, "@"); hasUser {
+			host = afterAt
+		}
+		remote = host + "/" + strings.TrimPrefix(path, "/")
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+}
+
+// remoteMatches checks if a remote URL matches a rule pattern, where * matches any sequence of characters
+func remoteMatches(pattern string, remote string) bool {
+	quoted := regexp.QuoteMeta(normalizeRemoteURL(pattern))
+	re, err := regexp.Compile("^" + strings.ReplaceAll(quoted, `\*`, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(normalizeRemoteURL(remote))
+}
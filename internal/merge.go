@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"slices"
+	"strings"
+)
+
+// matchLines maps each line of a to the line of b it is kept as in the edit script from a to b, or -1 if it is removed
+func matchLines(a []string, b []string) []int {
+	match := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range diffLines(a, b) {
+		switch op.kind {
+		case ' ':
+			match[i] = j
+			i++
+			j++
+		case '-':
+			match[i] = -1
+			i++
+		case '+':
+			j++
+		}
+	}
+	return match
+}
+
+// commonLines returns the longest common subsequence of two texts, used as the base of a merge when the
+// actual common ancestor is unknown. Lines only in one of them then count as added by that side
+func commonLines(ours string, theirs string) string {
+	var lines []string
+	for _, op := range diffLines(splitLines(ours), splitLines(theirs)) {
+		if op.kind == ' ' {
+			lines = append(lines, op.line)
+		}
+	}
+	return joinLines(lines)
+}
+
+// merge3 merges the changes from base to ours into theirs, like diff3, returning the number of regions
+// changed differently on both sides as conflicts, put in the result between conflict markers labelled with
+// the given names. If keepTheirs is set, such regions are instead kept as in theirs and counted as kept,
+// unless ours has more lines there, as those added lines would otherwise be lost
+func merge3(base string, ours string, theirs string, oursName string, theirsName string, keepTheirs bool) (string, int, int) {
+	o, a, b := splitLines(base), splitLines(ours), splitLines(theirs)
+	matchA, matchB := matchLines(o, a), matchLines(o, b)
+
+	var result []string
+	conflicts, kept := 0, 0
+	iO, iA, iB := 0, 0, 0
+	for iO < len(o) || iA < len(a) || iB < len(b) {
+		// a base line kept on both sides is stable
+		if iO < len(o) && matchA[iO] == iA && matchB[iO] == iB {
+			result = append(result, o[iO])
+			iO, iA, iB = iO+1, iA+1, iB+1
+			continue
+		}
+
+		// otherwise the changed region extends to the next stable line, or the end
+		next := iO
+		for next < len(o) && (matchA[next] < 0 || matchB[next] < 0) {
+			next++
+		}
+		endA, endB := len(a), len(b)
+		if next < len(o) {
+			endA, endB = matchA[next], matchB[next]
+		}
+		chunkO, chunkA, chunkB := o[iO:next], a[iA:endA], b[iB:endB]
+
+		switch {
+		case slices.Equal(chunkA, chunkO):
+			result = append(result, chunkB...)
+		case slices.Equal(chunkB, chunkO) || slices.Equal(chunkA, chunkB):
+			result = append(result, chunkA...)
+		case keepTheirs && len(chunkA) <= len(chunkB):
+			kept++
+			result = append(result, chunkB...)
+		default:
+			conflicts++
+			result = append(result, "<<<<<<< "+oursName)
+			result = append(result, chunkA...)
+			result = append(result, "=======")
+			result = append(result, chunkB...)
+			result = append(result, ">>>>>>> "+theirsName)
+		}
+		iO, iA, iB = next, endA, endB
+	}
+	return joinLines(result), conflicts, kept
+}
+
+// joinLines is the inverse of splitLines
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
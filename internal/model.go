@@ -3,25 +3,35 @@ package internal
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 )
 
 type Path struct {
 	SrcPath       string            `json:"srcPath"`
+	StorageDir    string            `json:"storageDir"`
 	Status        Status            `json:"status"`
 	TgtPath       *string           `json:"tgtPath"`
 	ResolvedTgt   *DetectedProfile  `json:"resolvedTgt"`
 	DetectedProfs []DetectedProfile `json:"detectedProfs"`
+
+	// OverrideProfile is the profile this path was intentionally switched to by a partial 'set', if any
+	OverrideProfile string `json:"overrideProfile,omitempty"`
+	// Error is the filesystem error behind an error status, if any
+	Error string `json:"error,omitempty"`
+	// MissingSlot is the policy for switching to a profile without a slot for this path
+	MissingSlot string `json:"missingSlot,omitempty"`
 }
 
 func (path *Path) ProfsDir() (string, error) {
-	if path.TgtPath == nil {
-		return "", fmt.Errorf("target path is nil for source path: %s", path.SrcPath)
+	res := path.StorageDir
+	if res == "" {
+		return "", fmt.Errorf("storage directory is empty for source path: %s", path.SrcPath)
 	}
-	if *path.TgtPath == "" {
-		return "", fmt.Errorf("target path is empty for source path: %s", path.SrcPath)
+	exists, err := fileOrDirExists(res)
+	if err != nil {
+		return "", fmt.Errorf("failed to check profs directory %s: %w", res, err)
 	}
-	res := filepath.Dir(*path.TgtPath)
-	if fileOrDirExists(res) {
+	if exists {
 		return res, nil
 	} else {
 		return "", fmt.Errorf("target profs directory does not exist: %s", res)
@@ -39,17 +49,87 @@ func (path *Path) ActiveProfile() (string, error) {
 	return prof, nil
 }
 
+// Problem describes what is wrong with a path with an error status, or returns an empty string
+func (path *Path) Problem() string {
+	msg := ""
+	switch path.Status {
+	case StatusErrorSrcNotFound:
+		msg = fmt.Sprintf("Source path %s does not exist", path.SrcPath)
+	case StatusErrorSrcNotSymlink:
+		msg = fmt.Sprintf("Source path %s is not a symlink", path.SrcPath)
+	case StatusErrorTgtNotFound:
+		msg = fmt.Sprintf("Source path %s points to a missing target", path.SrcPath)
+	case StatusErrorTgtUnresolvable:
+		msg = fmt.Sprintf("Source path %s points outside its storage directory %s", path.SrcPath, path.StorageDir)
+	case StatusErrorPermissionDenied:
+		msg = fmt.Sprintf("Permission denied while inspecting path %s", path.SrcPath)
+	case StatusErrorSymlinkLoop:
+		msg = fmt.Sprintf("Source path %s is part of a symlink loop", path.SrcPath)
+	case StatusErrorStorageNotFound:
+		msg = fmt.Sprintf("Storage directory %s of path %s does not exist", path.StorageDir, path.SrcPath)
+	case StatusErrorWrongSlotType:
+		msg = fmt.Sprintf("Active slot of path %s has the wrong type", path.SrcPath)
+	case StatusErrorIO:
+		msg = fmt.Sprintf("Failed to inspect path %s", path.SrcPath)
+	}
+	if msg != "" && path.Error != "" {
+		msg += ": " + path.Error
+	}
+	return msg
+}
+
 type Status string
 
 const (
-	StatusOk                   Status = "ok"
-	StatusErrorSrcNotFound     Status = "error_src_not_found"
-	StatusErrorTgtNotFound     Status = "error_tgt_not_found"
-	StatusErrorSrcNotSymlink   Status = "error_tgt_not_prof"
-	StatusErrorTgtUnresolvable Status = "error_tgt_not_resolvable"
+	StatusOk                    Status = "ok"
+	StatusErrorSrcNotFound      Status = "error_src_not_found"
+	StatusErrorTgtNotFound      Status = "error_tgt_not_found"
+	StatusErrorSrcNotSymlink    Status = "error_tgt_not_prof"
+	StatusErrorTgtUnresolvable  Status = "error_tgt_not_resolvable"
+	StatusErrorPermissionDenied Status = "error_permission_denied"
+	StatusErrorSymlinkLoop      Status = "error_symlink_loop"
+	// StatusErrorStorageNotFound means the storage directory holding the slots of the path is missing
+	StatusErrorStorageNotFound Status = "error_storage_not_found"
+	// StatusErrorWrongSlotType means the active slot is a file where the other slots are directories, or vice versa
+	StatusErrorWrongSlotType Status = "error_wrong_slot_type"
+	// StatusErrorIO covers any other filesystem error, see Path.Error
+	StatusErrorIO Status = "error_io"
+	// StatusEmpty means the path intentionally points to an empty placeholder, see MissingSlotEmpty
+	StatusEmpty Status = "empty"
 )
 
+// emptySlotName is the placeholder slot in a storage directory used by MissingSlotEmpty.
+// It is never detected as a profile, see isReservedSlotName.
+const emptySlotName = ".empty"
+
+type MissingSlotPolicy string
+
+const (
+	MissingSlotKeep     MissingSlotPolicy = "keep"
+	MissingSlotFail     MissingSlotPolicy = "fail"
+	MissingSlotEmpty    MissingSlotPolicy = "empty"
+	MissingSlotFallback MissingSlotPolicy = "fallback"
+)
+
+// parseMissingSlotPolicy parses a policy from the config, returning the fallback profile for "fallback:<profile>"
+func parseMissingSlotPolicy(s string) (MissingSlotPolicy, string, error) {
+	switch {
+	case s == "" || s == string(MissingSlotKeep):
+		return MissingSlotKeep, "", nil
+	case s == string(MissingSlotFail):
+		return MissingSlotFail, "", nil
+	case s == string(MissingSlotEmpty):
+		return MissingSlotEmpty, "", nil
+	case strings.HasPrefix(s, string(MissingSlotFallback)+":") && len(s) > len(MissingSlotFallback)+1:
+		return MissingSlotFallback, s[len(MissingSlotFallback)+1:], nil
+	default:
+		return "", "", fmt.Errorf("invalid missing slot policy '%s', expected one of keep, fail, empty or fallback:<profile>", s)
+	}
+}
+
 type DetectedProfile struct {
 	Name string
 	Path string
+	// Sealed means Path is the encrypted file of a locked slot, instead of the slot itself
+	Sealed bool
 }
@@ -0,0 +1,426 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+)
+
+// OutputFormat is the format of command results, selected with the global --output flag
+type OutputFormat string
+
+const (
+	OutputText OutputFormat = "text"
+	OutputJson OutputFormat = "json"
+	OutputYaml OutputFormat = "yaml"
+)
+
+// Output is the format requested for the current invocation
+var Output = OutputText
+
+func (o *OutputFormat) String() string { return string(*o) }
+
+func (o *OutputFormat) Set(s string) error {
+	switch OutputFormat(s) {
+	case OutputText, OutputJson, OutputYaml:
+		*o = OutputFormat(s)
+		return nil
+	default:
+		return fmt.Errorf("invalid output format '%s', expected one of text, json or yaml", s)
+	}
+}
+
+func (o *OutputFormat) Type() string { return "format" }
+
+// AddOutputFlag registers the global --output flag on the root command
+func AddOutputFlag(cmd *cobra.Command) {
+	Output = OutputText
+	cmd.PersistentFlags().VarP(&Output, "output", "o", "Output format of command results: text, json or yaml")
+}
+
+// Exit codes, documented in docs/reference/commands.md
+const (
+	ExitOk = 0
+	// ExitError is any failure of a command, including aborting on invalid paths
+	ExitError = 1
+	// ExitUsage means invalid arguments or flags
+	ExitUsage = 2
+	// ExitFindings means the command ran, but found problems, e.g. 'profs doctor' warnings
+	ExitFindings = 3
+	// ExitConfig means the config file could not be loaded
+	ExitConfig = 4
+)
+
+// resultSchemaVersion is bumped on incompatible changes to any result below.
+// Fields may be added without bumping it
+const resultSchemaVersion = 1
+
+// resultHeader identifies a result document, so scripts can check what they are parsing
+type resultHeader struct {
+	Kind          string `json:"kind"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+func newResultHeader(kind string) resultHeader {
+	return resultHeader{Kind: kind, SchemaVersion: resultSchemaVersion}
+}
+
+type ErrorResult struct {
+	resultHeader
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type PathResult struct {
+	Path       string `json:"path"`
+	Profile    string `json:"profile,omitempty"`
+	Target     string `json:"target,omitempty"`
+	StorageDir string `json:"storageDir"`
+	Status     Status `json:"status"`
+	Error      string `json:"error,omitempty"`
+	// Override is the profile the path was switched to separately by a partial 'set'
+	Override string `json:"override,omitempty"`
+}
+
+func pathResultOf(p Path) PathResult {
+	res := PathResult{
+		Path:       p.SrcPath,
+		Target:     lo.FromPtr(p.TgtPath),
+		StorageDir: p.StorageDir,
+		Status:     p.Status,
+		Error:      p.Error,
+		Override:   p.OverrideProfile,
+	}
+	if p.ResolvedTgt != nil {
+		res.Profile = p.ResolvedTgt.Name
+	}
+	return res
+}
+
+type StatusResult struct {
+	resultHeader
+	// Profile is the active profile, mix, or base profile of an intended mixed state. Empty if none is active
+	Profile        string       `json:"profile"`
+	ActiveProfiles []string     `json:"activeProfiles"`
+	Drift          bool         `json:"drift"`
+	Paths          []PathResult `json:"paths"`
+}
+
+func statusResultOf(gc GlobalConfig) StatusResult {
+	state := StateOf(gc)
+	return StatusResult{
+		resultHeader:   newResultHeader("status"),
+		Profile:        state.Profile,
+		ActiveProfiles: orEmpty(state.ActiveProfiles),
+		Drift:          state.Drift,
+		Paths:          orEmpty(lo.Map(gc.Paths, func(p Path, _ int) PathResult { return pathResultOf(p) })),
+	}
+}
+
+type StatusProfileResult struct {
+	resultHeader
+	Profile string `json:"profile"`
+	// State is one of none, single, mix, mixed (intended, after a partial 'set') or multiple (unintended)
+	State          string           `json:"state"`
+	ActiveProfiles []string         `json:"activeProfiles"`
+	Resolved       bool             `json:"resolved"`
+	Overrides      []OverrideResult `json:"overrides"`
+}
+
+type OverrideResult struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+}
+
+type ListResult struct {
+	resultHeader
+	Profiles []ProfileResult `json:"profiles"`
+	Mixes    []string        `json:"mixes"`
+}
+
+type ProfileResult struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+	// Locked is true if any slot of the profile is sealed by 'profs lock'
+	Locked bool `json:"locked"`
+}
+
+type DoctorResult struct {
+	resultHeader
+	Findings []Finding `json:"findings"`
+	Warnings int       `json:"warnings"`
+}
+
+type Finding struct {
+	// Severity is info or warn
+	Severity string `json:"severity"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+type SetResult struct {
+	resultHeader
+	Profile string               `json:"profile"`
+	Paths   []SwitchedPathResult `json:"paths"`
+}
+
+type SwitchedPathResult struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+	Target  string `json:"target"`
+}
+
+type AddResult struct {
+	resultHeader
+	Path       string `json:"path"`
+	Profile    string `json:"profile"`
+	StorageDir string `json:"storageDir"`
+}
+
+type AddProfileResult struct {
+	resultHeader
+	Profile string   `json:"profile"`
+	Slots   []string `json:"slots"`
+}
+
+type TrashListResult struct {
+	resultHeader
+	Entries []TrashEntryResult `json:"entries"`
+}
+
+type TrashEntryResult struct {
+	ID        string    `json:"id"`
+	Profile   string    `json:"profile"`
+	RemovedAt time.Time `json:"removedAt"`
+	Paths     []string  `json:"paths"`
+}
+
+type ExportResult struct {
+	resultHeader
+	Profile   string   `json:"profile"`
+	File      string   `json:"file"`
+	Paths     []string `json:"paths"`
+	Encrypted bool     `json:"encrypted"`
+	Signed    bool     `json:"signed"`
+}
+
+type ImportResult struct {
+	resultHeader
+	Profile string   `json:"profile"`
+	Paths   []string `json:"paths"`
+	// Added are the paths that were not managed by profs before the import
+	Added []string `json:"added"`
+	// SignedBy is the public key that signed the archive, if it is signed
+	SignedBy string `json:"signedBy,omitempty"`
+}
+
+type HistoryResult struct {
+	resultHeader
+	Path    string `json:"path"`
+	Profile string `json:"profile,omitempty"`
+	// Entries are the recorded versions, newest first
+	Entries []HistoryEntryResult `json:"entries"`
+}
+
+type HistoryEntryResult struct {
+	Rev     string    `json:"rev"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+}
+
+type RestoreResult struct {
+	resultHeader
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+	Rev     string `json:"rev"`
+}
+
+type SnapshotCreateResult struct {
+	resultHeader
+	ID    string `json:"id"`
+	Slots int    `json:"slots"`
+}
+
+type SnapshotListResult struct {
+	resultHeader
+	Snapshots []SnapshotEntryResult `json:"snapshots"`
+}
+
+type SnapshotEntryResult struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Message   string    `json:"message,omitempty"`
+	Slots     int       `json:"slots"`
+}
+
+type SnapshotDiffResult struct {
+	resultHeader
+	From    string           `json:"from"`
+	To      string           `json:"to"`
+	Changes []SnapshotChange `json:"changes"`
+}
+
+type SnapshotRestoreResult struct {
+	resultHeader
+	ID    string               `json:"id"`
+	Slots []SnapshotSlotResult `json:"slots"`
+	// Config is true if the config file was restored as well
+	Config bool `json:"config"`
+}
+
+type SnapshotSlotResult struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+}
+
+type DiffResult struct {
+	resultHeader
+	From  string           `json:"from"`
+	To    string           `json:"to"`
+	Paths []DiffPathResult `json:"paths"`
+}
+
+type DiffPathResult struct {
+	Path string `json:"path"`
+	// OnlyIn is the profile that has a slot for the path, if only one of them has
+	OnlyIn string           `json:"onlyIn,omitempty"`
+	Files  []DiffFileResult `json:"files"`
+}
+
+type DiffFileResult struct {
+	File string `json:"file"`
+	// Change is one of added, removed or changed
+	Change string `json:"change"`
+	// Kind is one of text, binary, secret, dir or symlink, and for changes also mode or type
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+	// Diff is the unified diff of a changed text file, with secret values masked
+	Diff string `json:"diff,omitempty"`
+}
+
+type PropagateResult struct {
+	resultHeader
+	File    string                  `json:"file"`
+	From    string                  `json:"from"`
+	Targets []PropagateTargetResult `json:"targets"`
+}
+
+type PropagateTargetResult struct {
+	Profile string `json:"profile"`
+	// Status is one of created, updated, merged, unchanged or conflict
+	Status string `json:"status"`
+	// Conflicts is the number of regions changed in both profiles since the last propagation
+	Conflicts int `json:"conflicts,omitempty"`
+	// Kept is the number of differing regions kept as they were in the target, when merging without an earlier propagation
+	Kept int `json:"kept,omitempty"`
+	// Diff is the unified diff of the target file, with secret values masked
+	Diff string `json:"diff,omitempty"`
+}
+
+// machineOutput is true when stdout is reserved for a json or yaml result
+func machineOutput() bool {
+	return Output != OutputText
+}
+
+// infof prints progress and diagnostics. They go to stderr with machine output,
+// so that stdout only holds the result document
+func infof(format string, args ...any) {
+	if machineOutput() {
+		_, _ = fmt.Fprintf(os.Stderr, format, args...)
+	} else {
+		fmt.Printf(format, args...)
+	}
+}
+
+// printResult prints a result in the requested format, calling text for text output
+func printResult(result any, text func()) {
+	switch Output {
+	case OutputJson:
+		fmt.Println(PrettyJson(result))
+	case OutputYaml:
+		fmt.Print(toYaml(result))
+	default:
+		if text != nil {
+			text()
+		}
+	}
+}
+
+// toYaml renders a result with the same field names and order as its json form
+func toYaml(result any) string {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(PrettyJson(result)), &node); err != nil {
+		panic(fmt.Sprintf("Failed to convert result to yaml: %v", err))
+	}
+	clearYamlStyle(&node)
+	var sb strings.Builder
+	enc := yaml.NewEncoder(&sb)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		panic(fmt.Sprintf("Failed to marshal result to yaml: %v", err))
+	}
+	return sb.String()
+}
+
+// clearYamlStyle switches the json flow style and quoting to plain yaml, which quotes where needed
+func clearYamlStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYamlStyle(child)
+	}
+}
+
+// exitWithCode exits after a result has already been printed, writing msg to stderr
+func exitWithCode(code int, msg string) {
+	if TestMode {
+		panic("ExitWithMsg called in test mode, code: " + fmt.Sprint(code) + ", msg: " + msg)
+	}
+	_, _ = fmt.Fprintln(os.Stderr, msg)
+	os.Exit(code)
+}
+
+func orEmpty[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+type GrepResult struct {
+	resultHeader
+	Pattern string            `json:"pattern"`
+	Matches []GrepMatchResult `json:"matches"`
+	// Skipped are the files that only hold secrets, which are never searched
+	Skipped []GrepSkippedResult `json:"skipped"`
+}
+
+type GrepMatchResult struct {
+	Profile string `json:"profile"`
+	// Path is where the file appears when the profile is active
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	// Text is the matching line, with secret values masked
+	Text string `json:"text"`
+}
+
+type GrepSkippedResult struct {
+	Profile string `json:"profile"`
+	Path    string `json:"path"`
+}
+
+type WhichResult struct {
+	resultHeader
+	File    string             `json:"file"`
+	Matches []WhichMatchResult `json:"matches"`
+}
+
+type WhichMatchResult struct {
+	Profile string `json:"profile"`
+	// Path is where the file appears when the profile is active
+	Path string `json:"path"`
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/samber/lo"
+)
+
+// propagatedBase records the content of a file when it was last propagated to a profile, which is
+// the common ancestor for merging the next propagation. The content is kept in the snapshot object store
+type propagatedBase struct {
+	// Path is the managed path, as written in the config
+	Path string `json:"path"`
+	// File is the file within the slot, "." for a file slot
+	File    string `json:"file"`
+	Profile string `json:"profile"`
+	Sha256  string `json:"sha256"`
+}
+
+// PropagatedBasesPath is the file recording the last propagated version of each file and profile
+func PropagatedBasesPath() string {
+	if TestMode {
+		return filepath.Join(ConfigDir(), "propagated.test.json")
+	}
+	return filepath.Join(ConfigDir(), "propagated.json")
+}
+
+func loadPropagatedBases() ([]propagatedBase, error) {
+	bytes, err := os.ReadFile(PropagatedBasesPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bases []propagatedBase
+	if err := json.Unmarshal(bytes, &bases); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", PropagatedBasesPath(), err)
+	}
+	return bases, nil
+}
+
+func savePropagatedBases(bases []propagatedBase) error {
+	bytes, err := json.MarshalIndent(bases, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(PropagatedBasesPath(), bytes, 0600)
+}
+
+// findPropagatedBase returns the content last propagated to a file of a profile, if it is known
+func findPropagatedBase(bases []propagatedBase, absPath string, file string, profile string) (string, bool) {
+	base, found := lo.Find(bases, func(b propagatedBase) bool {
+		return pathsAreEqual(expandHomePath(b.Path), absPath) && b.File == file && b.Profile == profile
+	})
+	if !found {
+		return "", false
+	}
+	content, err := os.ReadFile(snapshotObjectPath(base.Sha256))
+	if err != nil {
+		return "", false // without it, the merge falls back to not knowing the base
+	}
+	return string(content), true
+}
+
+// renamePropagatedBases moves the versions propagated to a profile to its new name
+func renamePropagatedBases(bases []propagatedBase, from string, to string) []propagatedBase {
+	return lo.Map(bases, func(b propagatedBase, _ int) propagatedBase {
+		if b.Profile == from {
+			b.Profile = to
+		}
+		return b
+	})
+}
+
+// setPropagatedBase records the content propagated to a file of a profile, storing it by its checksum
+func setPropagatedBase(bases []propagatedBase, absPath string, file string, profile string, source string) ([]propagatedBase, error) {
+	hash, err := sha256File(source)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeSnapshotObject(source, hash); err != nil {
+		return nil, err
+	}
+	bases = lo.Reject(bases, func(b propagatedBase, _ int) bool {
+		return pathsAreEqual(expandHomePath(b.Path), absPath) && b.File == file && b.Profile == profile
+	})
+	return append(bases, propagatedBase{Path: toConfigPath(absPath), File: file, Profile: profile, Sha256: hash}), nil
+}
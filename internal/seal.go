@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+// A sealed slot is an encrypted archive of the slot, kept in the storage directory as .<profile>.sealed
+// instead of the plaintext slot, see 'profs lock'
+const sealedSlotSuffix = ".sealed"
+
+func sealedSlotPath(storageDir string, profile string) string {
+	return filepath.Join(storageDir, "."+profile+sealedSlotSuffix)
+}
+
+// sealedProfileName returns the profile of a sealed slot file name, if it is one
+func sealedProfileName(fileName string) (string, bool) {
+	if !strings.HasPrefix(fileName, ".") || !strings.HasSuffix(fileName, sealedSlotSuffix) {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(fileName, "."), sealedSlotSuffix)
+	return name, name != ""
+}
+
+// sealSlot encrypts a slot into its sealed file, and then removes the plaintext slot
+func sealSlot(slot string, sealed string, keys *keyring) error {
+	manifest, err := newArchiveManifest(filepath.Base(slot), []string{slot}, []string{slot}, time.Now())
+	if err != nil {
+		return err
+	}
+	recipients, err := keys.Recipients()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	encrypted, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return err
+	}
+	if err := writeArchive(encrypted, manifest, []string{slot}, nil); err != nil {
+		return err
+	}
+	if err := encrypted.Close(); err != nil {
+		return err
+	}
+
+	// the sealed file must be complete before the slot is removed
+	tmp := sealed + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, sealed); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.RemoveAll(slot)
+}
+
+// unsealSlot decrypts a sealed file back into its slot, and then removes the sealed file
+func unsealSlot(sealed string, slot string, keys *keyring) error {
+	if fileOrDirExistsOrExit(slot) {
+		return fmt.Errorf("'%s' already exists", slot)
+	}
+	f, err := os.Open(sealed)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	decrypted, err := keys.decrypt(f)
+	if err != nil {
+		return err
+	}
+
+	// extracted next to the slot, so it can be renamed into place. Its name keeps it from being detected as a profile
+	tmpDir, err := os.MkdirTemp(filepath.Dir(slot), ".unseal-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	manifest, err := readArchive(decrypted, tmpDir, nil)
+	if err != nil {
+		return err
+	}
+	if len(manifest.Paths) != 1 {
+		return fmt.Errorf("sealed slot '%s' holds %d slots, expected 1", sealed, len(manifest.Paths))
+	}
+	if err := os.Rename(filepath.Join(tmpDir, filepath.FromSlash(archiveSlotName(0))), slot); err != nil {
+		return err
+	}
+	return os.Remove(sealed)
+}
+
+// exitIfSealed refuses to act on a profile with sealed slots, which must be unlocked first
+func exitIfSealed(gc GlobalConfig, profile string) {
+	if gc.IsLocked(profile) {
+		ExitWithMsg(1, fmt.Sprintf("Profile '%s' is locked, run 'profs unlock --profile %s' first", profile, profile))
+	}
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signatureNamespace is prepended to the signed manifest, so that the signature can't be
+// mistaken for one of anything else signed with the same key
+const signatureNamespace = "profs-archive-manifest-v1\n"
+
+// ArchiveSignature is the signature of an archive manifest. As the manifest holds the checksums
+// of all files, it covers the whole archive
+type ArchiveSignature struct {
+	// PublicKey is the signing key in authorized_keys format, e.g. "ssh-ed25519 AAAA..."
+	PublicKey string `json:"publicKey"`
+	Signature []byte `json:"signature"`
+}
+
+func signManifest(key ed25519.PrivateKey, manifest []byte) (ArchiveSignature, error) {
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return ArchiveSignature{}, fmt.Errorf("failed to encode the public key of the signing key: %w", err)
+	}
+	return ArchiveSignature{
+		PublicKey: formatPublicKey(publicKey),
+		Signature: ed25519.Sign(key, append([]byte(signatureNamespace), manifest...)),
+	}, nil
+}
+
+// verify checks the signature of a manifest, returning the key that signed it
+func (s ArchiveSignature) verify(manifest []byte) (ssh.PublicKey, error) {
+	publicKey, key, err := parseSigner(s.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, append([]byte(signatureNamespace), manifest...), s.Signature) {
+		return nil, errors.New("invalid signature, the archive has been modified since it was signed")
+	}
+	return publicKey, nil
+}
+
+// loadSigningKey reads an OpenSSH ed25519 private key, asking for its passphrase if it has one
+func loadSigningKey(file string) (ed25519.PrivateKey, error) {
+	bytes, err := os.ReadFile(expandHomePath(file))
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParseRawPrivateKey(bytes)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase, err := readPassphrase("Passphrase for "+file, false)
+		if err != nil {
+			return nil, err
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(bytes, []byte(passphrase))
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *ed25519.PrivateKey:
+		return *k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("'%s' is not an ed25519 key", file)
+	}
+}
+
+// parseSigner parses an ed25519 public key in authorized_keys format, or the .pub file holding one
+func parseSigner(s string) (ssh.PublicKey, ed25519.PublicKey, error) {
+	if !strings.HasPrefix(s, "ssh-") {
+		bytes, err := os.ReadFile(expandHomePath(s))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid signer '%s', expected a public key like 'ssh-ed25519 AAAA...' or a file holding one", s)
+		}
+		s = string(bytes)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid signer '%s': %w", strings.TrimSpace(s), err)
+	}
+	cryptoKey, ok := publicKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid signer '%s', expected an ed25519 key", strings.TrimSpace(s))
+	}
+	key, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid signer '%s', expected an ed25519 key", strings.TrimSpace(s))
+	}
+	return publicKey, key, nil
+}
+
+// formatPublicKey formats a key like in authorized_keys, without a comment
+func formatPublicKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// verifySigners returns a check for archive signatures. With trusted signers, archives must be signed
+// by one of them. Without, signatures are still verified, so a modified archive is never accepted
+func verifySigners(trusted []string, signedBy *string) func(manifest []byte, signature *ArchiveSignature) error {
+	return func(manifest []byte, signature *ArchiveSignature) error {
+		if signature == nil {
+			if len(trusted) > 0 {
+				return errors.New("archive is not signed, but a trusted signer is required")
+			}
+			return nil
+		}
+
+		signer, err := signature.verify(manifest)
+		if err != nil {
+			return err
+		}
+		*signedBy = formatPublicKey(signer)
+		if len(trusted) == 0 {
+			infof("Archive is signed by %s, which is not a trusted signer\n", *signedBy)
+			return nil
+		}
+		for _, t := range trusted {
+			trustedKey, _, err := parseSigner(t)
+			if err != nil {
+				return err
+			}
+			if formatPublicKey(trustedKey) == *signedBy {
+				infof("Verified signature of %s\n", *signedBy)
+				return nil
+			}
+		}
+		return fmt.Errorf("archive is signed by %s, which is not a trusted signer", *signedBy)
+	}
+}
@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// A snapshot records the slots of all profiles and the config at one point in time. File contents
+// are stored once by their sha256 under objects/, so unchanged files cost nothing in later snapshots.
+// Each snapshot is a <id>.json manifest next to it
+
+const snapshotObjectsDir = "objects"
+
+// Snapshot is a point-in-time copy of all slots, see 'profs snapshot'
+type Snapshot struct {
+	ID        string         `json:"id"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Message   string         `json:"message,omitempty"`
+	Slots     []SnapshotSlot `json:"slots"`
+	// Config is the object holding the config file at the time of the snapshot
+	Config string `json:"config"`
+}
+
+// SnapshotSlot is the slot of one profile for one managed path
+type SnapshotSlot struct {
+	// Path is the managed path, as written in the config
+	Path string `json:"path"`
+	// StorageDir is where the slot was, and is restored to if the path is no longer managed
+	StorageDir string `json:"storageDir"`
+	Profile    string `json:"profile"`
+	// Sealed means the slot was locked, and Files hold its sealed file
+	Sealed bool          `json:"sealed,omitempty"`
+	Files  []ArchiveFile `json:"files"`
+}
+
+// SnapshotsDir is kept in the config directory, and survives 'profs reset'
+func SnapshotsDir() string {
+	if TestMode {
+		return filepath.Join(ConfigDir(), "snapshots.test")
+	}
+	return filepath.Join(ConfigDir(), "snapshots")
+}
+
+func snapshotObjectPath(hash string) string {
+	return filepath.Join(SnapshotsDir(), snapshotObjectsDir, hash[:2], hash)
+}
+
+// slotName is the name of the slot within its storage directory
+func (s SnapshotSlot) slotName() string {
+	if s.Sealed {
+		return filepath.Base(sealedSlotPath("", s.Profile))
+	}
+	return s.Profile
+}
+
+// createSnapshot records all slots of all managed paths, as well as the config file
+func createSnapshot(gc GlobalConfig, message string, now time.Time) (Snapshot, error) {
+	if err := os.MkdirAll(SnapshotsDir(), 0700); err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{ID: now.UTC().Format("20060102T150405Z"), CreatedAt: now, Message: message}
+	for i := 2; fileOrDirExistsOrExit(snapshotManifestPath(snapshot.ID)); i++ {
+		snapshot.ID = fmt.Sprintf("%s-%d", now.UTC().Format("20060102T150405Z"), i)
+	}
+
+	for _, p := range gc.Paths {
+		for _, prof := range p.DetectedProfs {
+			manifest, err := newArchiveManifest(prof.Name, []string{p.SrcPath}, []string{prof.Path}, now)
+			if err != nil {
+				return Snapshot{}, err
+			}
+			files := manifest.Paths[0].Files
+			for _, f := range files {
+				if f.Type == "file" {
+					if err := storeSnapshotObject(filepath.Join(prof.Path, filepath.FromSlash(f.Name)), f.Sha256); err != nil {
+						return Snapshot{}, err
+					}
+				}
+			}
+			snapshot.Slots = append(snapshot.Slots, SnapshotSlot{
+				Path:       toConfigPath(p.SrcPath),
+				StorageDir: p.StorageDir,
+				Profile:    prof.Name,
+				Sealed:     prof.Sealed,
+				Files:      files,
+			})
+		}
+	}
+
+	configHash, err := sha256File(GlobalConfigPath())
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if err := storeSnapshotObject(GlobalConfigPath(), configHash); err != nil {
+		return Snapshot{}, err
+	}
+	snapshot.Config = configHash
+
+	bytes, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if err := os.WriteFile(snapshotManifestPath(snapshot.ID), bytes, 0600); err != nil {
+		return Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+func snapshotManifestPath(id string) string {
+	return filepath.Join(SnapshotsDir(), id+".json")
+}
+
+// storeSnapshotObject copies a file into the object store, unless an object with the same content exists
+func storeSnapshotObject(file string, hash string) error {
+	object := snapshotObjectPath(hash)
+	if fileOrDirExistsOrExit(object) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(object), 0700); err != nil {
+		return err
+	}
+	// objects hold secrets, so they are only readable by the user, whatever the mode of the original
+	tmp := object + ".tmp"
+	if err := copyFile(file, tmp, 0600); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, object)
+}
+
+// ListSnapshots returns all snapshots, oldest first
+func ListSnapshots() ([]Snapshot, error) {
+	entries, err := os.ReadDir(SnapshotsDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		bytes, err := os.ReadFile(filepath.Join(SnapshotsDir(), e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", e.Name(), err)
+		}
+		snapshot := Snapshot{}
+		if err := json.Unmarshal(bytes, &snapshot); err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot %s: %w", e.Name(), err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// findSnapshot returns the snapshot with the given id, which may be abbreviated as long as it is unique
+func findSnapshot(snapshots []Snapshot, id string) (Snapshot, error) {
+	if exact, found := lo.Find(snapshots, func(s Snapshot) bool { return s.ID == id }); found {
+		return exact, nil
+	}
+	matches := lo.Filter(snapshots, func(s Snapshot, _ int) bool { return strings.HasPrefix(s.ID, id) })
+	switch len(matches) {
+	case 0:
+		return Snapshot{}, fmt.Errorf("snapshot '%s' not found", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return Snapshot{}, fmt.Errorf("snapshot '%s' is ambiguous, it matches %s", id, strings.Join(lo.Map(matches, func(s Snapshot, _ int) string { return s.ID }), ", "))
+	}
+}
+
+// restoreSnapshotSlot replaces a slot, locked or not, with its content from a snapshot
+func restoreSnapshotSlot(fsys fsOps, slot SnapshotSlot, storageDir string) error {
+	for _, existing := range []string{filepath.Join(storageDir, slot.Profile), sealedSlotPath(storageDir, slot.Profile)} {
+		if fileOrDirExistsOrExit(existing) {
+			if err := fsys.RemoveAll(existing); err != nil {
+				return err
+			}
+		}
+	}
+	if err := fsys.MkdirAll(storageDir, 0755); err != nil {
+		return err
+	}
+
+	target := filepath.Join(storageDir, slot.slotName())
+	var dirs []ArchiveFile
+	for _, f := range slot.Files {
+		p := filepath.Join(target, filepath.FromSlash(f.Name))
+		switch f.Type {
+		case "dir":
+			// written as user-writable first, so the content can be restored below it
+			if err := fsys.MkdirAll(p, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, f)
+		case "symlink":
+			if err := fsys.Symlink(f.Target, p); err != nil {
+				return err
+			}
+		case "file":
+			bytes, err := os.ReadFile(snapshotObjectPath(f.Sha256))
+			if err != nil {
+				return fmt.Errorf("snapshot object of '%s' is missing: %w", p, err)
+			}
+			if err := fsys.WriteFile(p, bytes, f.Mode); err != nil {
+				return err
+			}
+		}
+	}
+	// directory modes last, deepest first, so read-only directories don't block their content
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := fsys.Chmod(filepath.Join(target, filepath.FromSlash(dirs[i].Name)), dirs[i].Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SnapshotChange is a difference between two snapshots
+type SnapshotChange struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile"`
+	// File is the file within the slot, empty if the whole slot was added or removed
+	File string `json:"file,omitempty"`
+	// Change is one of added, removed or changed
+	Change string `json:"change"`
+}
+
+// diffSnapshots lists the slots and files added, removed or changed from one snapshot to another
+func diffSnapshots(from Snapshot, to Snapshot) []SnapshotChange {
+	type slotKey struct{ path, profile string }
+	keyOf := func(s SnapshotSlot) slotKey { return slotKey{s.Path, s.Profile} }
+	fromSlots := lo.KeyBy(from.Slots, keyOf)
+	toSlots := lo.KeyBy(to.Slots, keyOf)
+
+	changes := []SnapshotChange{}
+	for _, s := range from.Slots {
+		if _, found := toSlots[keyOf(s)]; !found {
+			changes = append(changes, SnapshotChange{Path: s.Path, Profile: s.Profile, Change: "removed"})
+		}
+	}
+	for _, s := range to.Slots {
+		old, found := fromSlots[keyOf(s)]
+		if !found {
+			changes = append(changes, SnapshotChange{Path: s.Path, Profile: s.Profile, Change: "added"})
+			continue
+		}
+		if old.Sealed || s.Sealed {
+			// the content of locked slots can't be compared, only whether the sealed file changed
+			if old.Sealed != s.Sealed || !slices.Equal(old.Files, s.Files) {
+				changes = append(changes, SnapshotChange{Path: s.Path, Profile: s.Profile, Change: "changed"})
+			}
+			continue
+		}
+		oldFiles := lo.KeyBy(old.Files, func(f ArchiveFile) string { return f.Name })
+		newFiles := lo.KeyBy(s.Files, func(f ArchiveFile) string { return f.Name })
+		for _, f := range old.Files {
+			if _, found := newFiles[f.Name]; !found {
+				changes = append(changes, SnapshotChange{Path: s.Path, Profile: s.Profile, File: f.Name, Change: "removed"})
+			}
+		}
+		for _, f := range s.Files {
+			oldFile, found := oldFiles[f.Name]
+			switch {
+			case !found:
+				changes = append(changes, SnapshotChange{Path: s.Path, Profile: s.Profile, File: f.Name, Change: "added"})
+			case oldFile != f:
+				changes = append(changes, SnapshotChange{Path: s.Path, Profile: s.Profile, File: f.Name, Change: "changed"})
+			}
+		}
+	}
+	return changes
+}
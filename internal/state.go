@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// State is a small summary of the current profile state, written on every mutation so that
+// frequently run commands like 'profs prompt' don't have to load the config and probe every path
+type State struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Profile is the active profile, mix, or base profile of an intended mixed state. Empty if none is active
+	Profile string `json:"profile"`
+	// ActiveProfiles are all profiles some path points to
+	ActiveProfiles []string `json:"activeProfiles"`
+	// Drift is true if paths don't resolve, or point to different profiles without that being intended
+	Drift bool        `json:"drift"`
+	Color string      `json:"color,omitempty"`
+	Paths []StatePath `json:"paths"`
+}
+
+type StatePath struct {
+	SrcPath string `json:"srcPath"`
+	Target  string `json:"target"`
+}
+
+const stateVersion = 1
+
+func StateFilePath() string {
+	if TestMode {
+		return filepath.Join(ConfigDir(), "state.test.json")
+	}
+	return filepath.Join(ConfigDir(), "state.json")
+}
+
+// StateOf summarizes a loaded config
+func StateOf(gc GlobalConfig) State {
+	state := State{
+		Version:        stateVersion,
+		UpdatedAt:      time.Now(),
+		ActiveProfiles: gc.ActiveProfileNames(),
+		Drift:          !gc.AllProfilesResolved(),
+		Paths: lo.Map(gc.Paths, func(p Path, _ int) StatePath {
+			return StatePath{SrcPath: p.SrcPath, Target: lo.FromPtr(p.TgtPath)}
+		}),
+	}
+
+	activeState, profile := gc.ActiveState()
+	state.Profile = profile
+	if activeState == "multiple" {
+		state.Drift = true
+	}
+
+	state.Color = gc.Colors[state.Profile]
+	return state
+}
+
+func LoadState() (State, error) {
+	bytes, err := os.ReadFile(StateFilePath())
+	if err != nil {
+		return State{}, err
+	}
+	state := State{}
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		return State{}, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return state, nil
+}
+
+func SaveState(state State) error {
+	bytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(StateFilePath(), bytes, 0644)
+}
+
+// refreshState re-reads the config and paths and updates the state file.
+// Failures are only logged, the state file is a cache and must never fail a command
+func refreshState() {
+	gc, err := ReadGlobalConf()
+	if err != nil {
+		slog.Warn("Failed to load the config to update the state file", "error", err)
+		return
+	}
+	if err := SaveState(StateOf(gc)); err != nil {
+		slog.Warn("Failed to update state file", "path", StateFilePath(), "error", err)
+	}
+}
+
+// Verify checks that the first recorded path still points where the state says it does,
+// which catches most changes made outside profs at the cost of a single readlink
+func (s State) Verify() bool {
+	if len(s.Paths) == 0 {
+		return true
+	}
+	target, err := os.Readlink(s.Paths[0].SrcPath)
+	if err != nil {
+		return s.Paths[0].Target == ""
+	}
+	return target == s.Paths[0].Target
+}
+
+// DisplayName is how the state is shown in prompts
+func (s State) DisplayName() string {
+	if s.Profile != "" {
+		return s.Profile
+	}
+	return strings.Join(s.ActiveProfiles, "|")
+}
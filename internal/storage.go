@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// DefaultStorageRoot is where profile storage is placed by 'migrate-storage' if no storage root is configured
+func DefaultStorageRoot() string {
+	return filepath.Join(HomeDir(), ".local", "share", "profs")
+}
+
+// siblingStorageDir is the classic storage location, next to the managed path
+func siblingStorageDir(absPath string) string {
+	return absPath + ".profs"
+}
+
+// encodeStoragePath turns a managed path into a single directory name usable under the storage root,
+// e.g. ~/.config/gcloud -> .config%2Fgcloud and /etc/foo -> %2Fetc%2Ffoo
+func encodeStoragePath(absPath string) string {
+	return url.PathEscape(strings.TrimPrefix(toConfigPath(absPath), "~/"))
+}
+
+// rootStorageDir is the storage location of a managed path under the given storage root
+func rootStorageDir(root string, absPath string) string {
+	return filepath.Join(expandHomePath(root), encodeStoragePath(absPath))
+}
+
+// StorageDirFor returns the directory holding the profiles of the given managed path
+func (gcr GlobalConfigRaw) StorageDirFor(absPath string) string {
+	for p, storage := range gcr.Storage {
+		if pathsAreEqual(expandHomePath(p), absPath) {
+			return expandHomePath(storage)
+		}
+	}
+	return siblingStorageDir(absPath)
+}
+
+// storageKeyFor returns the key under which the storage location of absPath is recorded, if any
+func (gcr GlobalConfigRaw) storageKeyFor(absPath string) (string, bool) {
+	for p := range gcr.Storage {
+		if pathsAreEqual(expandHomePath(p), absPath) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// setStorageDir records the storage location of a managed path, or removes the record if it is the sibling default
+func (gcr *GlobalConfigRaw) setStorageDir(absPath string, storageDir string) {
+	if key, found := gcr.storageKeyFor(absPath); found {
+		delete(gcr.Storage, key)
+	}
+	if pathsAreEqual(storageDir, siblingStorageDir(absPath)) {
+		if len(gcr.Storage) == 0 {
+			gcr.Storage = nil
+		}
+		return
+	}
+	if gcr.Storage == nil {
+		gcr.Storage = map[string]string{}
+	}
+	gcr.Storage[toConfigPath(absPath)] = toConfigPath(storageDir)
+}
+
+// newStorageDirFor returns where storage for a newly added path should be placed
+func (gcr GlobalConfigRaw) newStorageDirFor(absPath string) string {
+	if gcr.StorageRoot != "" {
+		return rootStorageDir(gcr.StorageRoot, absPath)
+	}
+	return siblingStorageDir(absPath)
+}
+
+// moveFileOrDir moves src to dst, falling back to copy + delete when
+// a plain rename is not possible because src and dst are on different devices
+func moveFileOrDir(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyFileOrDir(src, dst); err != nil {
+		_ = removeAllWritable(dst)
+		return fmt.Errorf("failed to copy '%s' to '%s' across devices: %w", src, dst, err)
+	}
+	if err := removeAllWritable(src); err != nil {
+		return fmt.Errorf("copied '%s' to '%s' but failed to remove the original: %w", src, dst, err)
+	}
+	return nil
+}
+
+// copyFileOrDir recursively copies src to dst, preserving file modes and symlinks
+func copyFileOrDir(src string, dst string) error {
+	type copiedDir struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirs []copiedDir
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(linkTarget, target)
+		case info.IsDir():
+			dirs = append(dirs, copiedDir{path: target, mode: info.Mode().Perm()})
+			return os.MkdirAll(target, 0700)
+		case info.Mode().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("unsupported file type: %s", p)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// directories may be read-only, so their modes are applied once their content is in place
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAllWritable removes p like os.RemoveAll, first making its directories writable,
+// as the entries of a read-only directory can't be removed
+func removeAllWritable(p string) error {
+	_ = filepath.WalkDir(p, func(f string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			if info, err := d.Info(); err == nil && info.Mode().Perm()&0700 != 0700 {
+				_ = os.Chmod(f, info.Mode().Perm()|0700)
+			}
+		}
+		return nil
+	})
+	return os.RemoveAll(p)
+}
+
+func copyFile(src string, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrashEntry is a profile removed by 'profs remove-profile', kept until the trash is emptied
+type TrashEntry struct {
+	Profile   string      `json:"profile"`
+	RemovedAt time.Time   `json:"removedAt"`
+	Slots     []TrashSlot `json:"slots"`
+	// Refs are the config entries referring to the profile, removed with it and put back on restore
+	Refs *ProfileRefs `json:"refs,omitempty"`
+
+	// Dir is the directory of the entry in the trash
+	Dir string `json:"-"`
+}
+
+// TrashSlot is the slot of a removed profile for one managed path
+type TrashSlot struct {
+	// Path is the managed path, as written in the config
+	Path string `json:"path"`
+	// StorageDir is where the slot was removed from, and is restored to
+	StorageDir string `json:"storageDir"`
+	// Name is the name of the slot within the trash entry
+	Name string `json:"name"`
+}
+
+const trashManifestFile = "manifest.json"
+
+// TrashDir is kept in the config directory, and survives 'profs reset'
+func TrashDir() string {
+	if TestMode {
+		return filepath.Join(ConfigDir(), "trash.test")
+	}
+	return filepath.Join(ConfigDir(), "trash")
+}
+
+// newTrashEntry creates an entry for a profile about to be removed, without touching the disk
+func newTrashEntry(profile string, now time.Time) TrashEntry {
+	id := now.UTC().Format("20060102T150405.000000000") + "-" + profile
+	return TrashEntry{
+		Profile:   profile,
+		RemovedAt: now,
+		Dir:       filepath.Join(TrashDir(), id),
+	}
+}
+
+// ID identifies the entry, and is unique even for repeated removals of the same profile name
+func (e TrashEntry) ID() string {
+	return filepath.Base(e.Dir)
+}
+
+func (e TrashEntry) SlotPath(slot TrashSlot) string {
+	return filepath.Join(e.Dir, slot.Name)
+}
+
+func (e TrashEntry) Manifest() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// ListTrash returns all entries in the trash, oldest first
+func ListTrash() ([]TrashEntry, error) {
+	dirs, err := os.ReadDir(TrashDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []TrashEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []TrashEntry{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		entryDir := filepath.Join(TrashDir(), d.Name())
+		bytes, err := os.ReadFile(filepath.Join(entryDir, trashManifestFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trash entry %s: %w", entryDir, err)
+		}
+		entry := TrashEntry{}
+		if err := json.Unmarshal(bytes, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse trash entry %s: %w", entryDir, err)
+		}
+		entry.Dir = entryDir
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].RemovedAt.Before(entries[j].RemovedAt) })
+	return entries, nil
+}
+
+// parseAge parses durations like 30d, 2w or 12h
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, found := strings.CutSuffix(s, suffix); found {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid age '%s'", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age '%s', expected e.g. 30d, 2w or 12h", s)
+	}
+	return d, nil
+}
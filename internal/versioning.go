@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// With versioning enabled, each storage directory is a git repository, committed to whenever a
+// path is switched away from a profile. History is kept by the local git binary
+
+// versionExcludes are entries of a storage directory that are never committed
+var versionExcludes = []string{"/" + emptySlotName, "/.unseal-*", "*.sealed.tmp"}
+
+// slotModesFile records the permissions of the slots in each commit, as git only records the executable bit.
+// It is never detected as a profile, see isReservedSlotName
+const slotModesFile = ".modes.json"
+
+// HistoryEntry is a commit touching a storage directory
+type HistoryEntry struct {
+	Rev     string
+	Date    time.Time
+	Message string
+}
+
+// runGit runs git in a directory, returning its trimmed output
+func runGit(dir string, args ...string) (string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", errors.New("git was not found on PATH")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		name := args[0]
+		if name == "-c" && len(args) > 2 {
+			name = args[2]
+		}
+		return "", fmt.Errorf("git %s failed: %s", name, lo.CoalesceOrEmpty(strings.TrimSpace(stderr.String()), err.Error()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// isVersioned checks if a storage directory is a git repository created by profs
+func isVersioned(storageDir string) bool {
+	stat, err := os.Stat(filepath.Join(storageDir, ".git"))
+	return err == nil && stat.IsDir()
+}
+
+// ensureVersioned turns a storage directory into a git repository, if it isn't one yet. Commits are
+// made by "profs" and never signed, since they happen in the background of other commands
+func ensureVersioned(fsys fsOps, storageDir string) error {
+	if isVersioned(storageDir) {
+		return nil
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"config", "user.name", "profs"},
+		{"config", "user.email", "profs@localhost"},
+		{"config", "commit.gpgSign", "false"},
+	} {
+		if err := fsys.Git(storageDir, args...); err != nil {
+			return err
+		}
+	}
+	infoDir := filepath.Join(storageDir, ".git", "info")
+	if err := fsys.MkdirAll(infoDir, 0755); err != nil {
+		return err
+	}
+	return fsys.WriteFile(filepath.Join(infoDir, "exclude"), []byte(strings.Join(versionExcludes, "\n")+"\n"), 0644)
+}
+
+// commitStorage commits all changes in a storage directory, doing nothing if there are none
+func commitStorage(fsys fsOps, storageDir string, message string) error {
+	if err := ensureVersioned(fsys, storageDir); err != nil {
+		return err
+	}
+	if err := writeSlotModes(fsys, storageDir); err != nil {
+		return err
+	}
+	if isVersioned(storageDir) {
+		status, err := runGit(storageDir, "status", "--porcelain")
+		if err != nil {
+			return err
+		}
+		if status == "" {
+			return nil
+		}
+	}
+	if err := fsys.Git(storageDir, "add", "--all"); err != nil {
+		return err
+	}
+	// the user's hooks, e.g. secret scanners or 'profs guard', must neither see nor block commits of slots
+	return fsys.Git(storageDir, "-c", "core.hooksPath=/dev/null", "commit", "--quiet", "--no-verify", "--message", message)
+}
+
+// writeSlotModes records the permissions of everything in the slots of a storage directory, by path relative to it
+func writeSlotModes(fsys fsOps, storageDir string) error {
+	entries, err := os.ReadDir(storageDir)
+	if err != nil {
+		return err
+	}
+	modes := map[string]fs.FileMode{}
+	for _, entry := range entries {
+		if isReservedSlotName(entry.Name()) {
+			continue
+		}
+		err := filepath.WalkDir(filepath.Join(storageDir, entry.Name()), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.Type()&fs.ModeSymlink != 0 {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(storageDir, p)
+			if err != nil {
+				return err
+			}
+			modes[filepath.ToSlash(rel)] = info.Mode().Perm()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	bytes, err := json.MarshalIndent(modes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.WriteFile(filepath.Join(storageDir, slotModesFile), append(bytes, '\n'), 0644)
+}
+
+// restoreSlotModes reapplies the permissions recorded at a revision to the slot of a profile checked out from it,
+// deepest first, as directories may be read-only. Revisions recorded without permissions are left as git restored them
+func restoreSlotModes(fsys fsOps, storageDir string, rev string, profile string) error {
+	if _, err := runGit(storageDir, "cat-file", "-e", rev+":"+slotModesFile); err != nil {
+		return nil
+	}
+	out, err := runGit(storageDir, "show", rev+":"+slotModesFile)
+	if err != nil {
+		return err
+	}
+	modes := map[string]fs.FileMode{}
+	if err := json.Unmarshal([]byte(out), &modes); err != nil {
+		return fmt.Errorf("failed to parse the permissions recorded at revision %s: %w", shortRev(rev), err)
+	}
+
+	var files []string
+	for file := range modes {
+		if file == profile || strings.HasPrefix(file, profile+"/") {
+			files = append(files, file)
+		}
+	}
+	slices.Sort(files)
+	slices.Reverse(files)
+	for _, file := range files {
+		// empty directories are not recorded by git, so they are not restored either
+		if err := fsys.Chmod(filepath.Join(storageDir, filepath.FromSlash(file)), modes[file]); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// storageHistory lists the commits of a storage directory, newest first, optionally only those touching a profile
+func storageHistory(storageDir string, profile string) ([]HistoryEntry, error) {
+	args := []string{"log", "--format=%H%x00%aI%x00%s"}
+	if profile != "" {
+		args = append(args, "--", profile, "."+profile+sealedSlotSuffix)
+	}
+	out, err := runGit(storageDir, args...)
+	if err != nil {
+		// a repository without commits has no history yet
+		if _, headErr := runGit(storageDir, "rev-parse", "--verify", "--quiet", "HEAD"); headErr != nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []HistoryEntry
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\x00", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected date '%s' in git log: %w", fields[1], err)
+		}
+		entries = append(entries, HistoryEntry{Rev: fields[0], Date: date, Message: fields[2]})
+	}
+	return entries, nil
+}
+
+// resolveRev resolves a revision of a storage directory to a full commit hash
+func resolveRev(storageDir string, rev string) (string, error) {
+	hash, err := runGit(storageDir, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("revision '%s' not found", rev)
+	}
+	return hash, nil
+}
+
+// shortRev abbreviates a commit hash for messages
+func shortRev(rev string) string {
+	if len(rev) > 7 {
+		return rev[:7]
+	}
+	return rev
+}
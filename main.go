@@ -1,40 +1,70 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/GiGurra/boa/pkg/boa"
 	"github.com/GiGurra/profs/internal"
 	"github.com/spf13/cobra"
 )
 
 func main() {
-	mainCmd().Run()
+	// errors returned here are invalid arguments or flags, failures while running exit by themselves
+	if err := mainCmd().RunE(); err != nil {
+		internal.ExitWithMsg(internal.ExitUsage, fmt.Sprintf("Error: %v\nRun 'profs --help' for usage", err))
+	}
 }
 
 // For testability
 func mainCmd() *boa.Cmd {
 
-	gc := internal.LoadGlobalConf()
+	// Loaded on first use, so that --help, completion and commands not needing the config work even if it is broken
+	gc := internal.NewLazyGlobalConfig()
 
 	return &boa.Cmd{
 		Use:   "profs",
 		Short: "Manage user profiles",
+		InitFunc: func(_ any, cmd *cobra.Command) error {
+			internal.AddOutputFlag(cmd)
+			return nil
+		},
 		SubCmds: []*cobra.Command{
-			internal.MigrateConfigDir(gc),
+			internal.MigrateConfigDir(),
+			internal.MigrateStorageCmd(gc),
 			internal.AddCmd("add", gc),
 			internal.AddCmd("add-path", gc),
 			internal.AddProfileCmd(gc),
 			internal.DoctorCmd(gc),
-			internal.RemoveCmd("remove", gc),
-			internal.RemoveCmd("remove-path", gc),
+			internal.RemoveCmd("remove"),
+			internal.RemoveCmd("remove-path"),
+			internal.MovePathCmd(gc),
 			internal.RemoveProfileCmd(gc),
+			internal.RenameProfileCmd(gc),
+			internal.TrashCmd(gc),
+			internal.ExportCmd(gc),
+			internal.ImportCmd(gc),
+			internal.LockCmd(gc),
+			internal.UnlockCmd(gc),
+			internal.HistoryCmd(gc),
+			internal.RestoreCmd(gc),
+			internal.SnapshotCmd(gc),
+			internal.DiffCmd(gc),
+			internal.PropagateCmd(gc),
+			internal.GrepCmd(gc),
+			internal.WhichCmd(gc),
 			internal.ListProfilesCmd("list", gc),
 			internal.ListProfilesCmd("list-profiles", gc),
-			internal.ResetCmd(gc),
+			internal.ResetCmd(),
 			internal.SetCmd(gc),
-			internal.StatusRawCmd(gc),
+			internal.StatusRawCmd(),
 			internal.StatusCmd(gc),
 			internal.StatusProfileCmd(gc),
 			internal.FullStatusCmd(gc),
+			internal.HookCmd(),
+			internal.AutoCmd(gc),
+			internal.WhichProfileCmd(gc),
+			internal.GuardCmd(gc),
+			internal.PromptCmd(),
 		},
 	}
 }
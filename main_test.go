@@ -1,16 +1,25 @@
 package main
 
 import (
+	"archive/tar"
+	"crypto/ed25519"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 
+	"filippo.io/age"
 	"github.com/GiGurra/profs/internal"
 	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
 	"github.com/samber/lo"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestHelp(t *testing.T) {
@@ -129,6 +138,1450 @@ func TestRemoveProfile(t *testing.T) {
 	})
 }
 
+func TestRenameProfile(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "client-a"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "add-profile", "personal"},
+		{"profs", "rename-profile", "client-a", "acme"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		conf := internal.LoadGlobalConf()
+		expectedProfiles := []string{"acme", "personal"}
+		if diff := cmp.Diff(conf.DetectedProfileNames(), expectedProfiles); diff != "" {
+			t.Fatalf("Profiles mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(conf.ActiveProfileNames(), []string{"acme"}); diff != "" {
+			t.Fatalf("Active profiles mismatch (-got +want):\n%s", diff)
+		}
+
+		if !conf.AllProfilesResolved() {
+			t.Fatalf("Expected all paths to resolve after rename, got: %v", conf.Paths)
+		}
+
+		// the version last propagated to a profile is still merged against after renaming it
+		write := func(profile string, content string) {
+			if err := os.WriteFile(filepath.Join(dirToAdd1+".profs", profile, "config"), []byte(content), 0600); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+		write("acme", "a\nb\n")
+		write("personal", "a\nb\n")
+		runCmd(t, "profs", "propagate", filepath.Join(dirToAdd1, "config"), "--to", "personal")
+		runCmd(t, "profs", "rename-profile", "personal", "home")
+		write("acme", "a\nB\n")
+		write("home", "z\na\nb\n")
+		runCmd(t, "profs", "propagate", filepath.Join(dirToAdd1, "config"), "--to", "home")
+		if bytes, err := os.ReadFile(filepath.Join(dirToAdd1+".profs", "home", "config")); err != nil || string(bytes) != "z\na\nB\n" {
+			t.Fatalf("Expected a three-way merge, got: %q, %v", bytes, err)
+		}
+	})
+}
+
+func TestMovePath(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	newLocation := filepath.Join(testDir, "nested", "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "test"},
+		{"profs", "move-path", dirToAdd1, newLocation, "--keep-link"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		conf := internal.LoadGlobalConf()
+		if len(conf.Paths) != 1 || conf.Paths[0].SrcPath != newLocation {
+			t.Fatalf("Expected path to be moved to '%s', got: %v", newLocation, conf.Paths)
+		}
+		if conf.Paths[0].Status != internal.StatusOk {
+			t.Fatalf("Expected moved path to resolve, got status: %s", conf.Paths[0].Status)
+		}
+
+		compatTarget, err := os.Readlink(dirToAdd1)
+		if err != nil || compatTarget != newLocation {
+			t.Fatalf("Expected compatibility symlink to '%s', got: '%s' (err: %v)", newLocation, compatTarget, err)
+		}
+	})
+}
+
+func TestMigrateStorage(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	storageRoot := filepath.Join(testDir, "storage")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "test"},
+		{"profs", "migrate-storage", "--root", storageRoot, "--yes"},
+		{"profs", "add", dirToAdd2},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		conf := internal.LoadGlobalConf()
+		for _, p := range conf.Paths {
+			if filepath.Dir(p.StorageDir) != storageRoot {
+				t.Fatalf("Expected storage of '%s' under '%s', got: %s", p.SrcPath, storageRoot, p.StorageDir)
+			}
+			if p.Status != internal.StatusOk {
+				t.Fatalf("Expected path '%s' to resolve, got status: %s", p.SrcPath, p.Status)
+			}
+		}
+		if _, err := os.Stat(dirToAdd1 + ".profs"); !os.IsNotExist(err) {
+			t.Fatalf("Expected sibling storage to be moved away, got: %v", err)
+		}
+	})
+
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "test"},
+		{"profs", "migrate-storage", "--root", storageRoot, "--yes"},
+		{"profs", "migrate-storage", "--back", "--yes"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		conf := internal.LoadGlobalConf()
+		if conf.Paths[0].StorageDir != dirToAdd1+".profs" || conf.Paths[0].Status != internal.StatusOk {
+			t.Fatalf("Expected storage to be moved back next to the path, got: %v", conf.Paths[0])
+		}
+	})
+}
+
+func TestPartialSet(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "add-profile", "client-b"},
+		{"profs", "set", "client-b", "--path", dirToAdd2},
+		{"profs", "doctor"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		conf := internal.LoadGlobalConf()
+		if !conf.IsIntendedMixedState() {
+			t.Fatalf("Expected an intended mixed state, got: %v", conf.Paths)
+		}
+		if diff := cmp.Diff(conf.BaseProfileNames(), []string{"work"}); diff != "" {
+			t.Fatalf("Base profiles mismatch (-got +want):\n%s", diff)
+		}
+		if conf.Paths[1].OverrideProfile != "client-b" || conf.Paths[1].ResolvedTgt.Name != "client-b" {
+			t.Fatalf("Expected '%s' to be switched to client-b, got: %v", dirToAdd2, conf.Paths[1])
+		}
+		if !conf.IsActive("work") || conf.IsActive("client-b") {
+			t.Fatalf("Expected work to stay active for guard and auto, got: %v", conf.Paths)
+		}
+	})
+
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "set", "client-b", "--path", dirToAdd2},
+		{"profs", "set", "work"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		conf := internal.LoadGlobalConf()
+		if len(conf.OverriddenPaths()) != 0 {
+			t.Fatalf("Expected a full switch to clear overrides, got: %v", conf.OverriddenPaths())
+		}
+	})
+
+	// paths skipped because of their status get no override
+	testDir2 := mkTempDir()
+	defer func() { deleteDirAndContents(testDir2) }()
+	dirToAdd2, dirToAdd3 := mkDir(testDir2, "dir2"), mkDir(testDir2, "dir3")
+	runTest(t, [][]string{
+		{"profs", "add", mkDir(testDir2, "dir1"), "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "add", dirToAdd3},
+		{"profs", "add-profile", "client-b"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		if err := os.Remove(dirToAdd3); err != nil {
+			t.Fatalf("Failed to remove symlink: %v", err)
+		}
+		mkDir(dirToAdd3)
+		os.Args = []string{"profs", "set", "client-b", "--path", dirToAdd2, "--path", dirToAdd3}
+		if err := mainCmd().RunE(); err != nil {
+			t.Fatalf("Failed to run set: %v", err)
+		}
+
+		conf := internal.LoadGlobalConf()
+		if conf.Paths[1].OverrideProfile != "client-b" || conf.Paths[2].OverrideProfile != "" {
+			t.Fatalf("Expected only '%s' to be overridden, got: %v", dirToAdd2, conf.Paths)
+		}
+	})
+}
+
+func TestSetMix(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "add-profile", "prod"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.Mixes = map[string]map[string]string{
+			"oncall": {"dir1": "work", dirToAdd2: "prod"},
+		}
+		internal.SaveGlobalConfRaw(rawConf)
+
+		os.Args = []string{"profs", "set", "oncall"}
+		if err := mainCmd().RunE(); err != nil {
+			t.Fatalf("Expected no error setting mix, got: %v", err)
+		}
+
+		conf := internal.LoadGlobalConf()
+		if diff := cmp.Diff(conf.ActiveProfileNames(), []string{"work", "prod"}); diff != "" {
+			t.Fatalf("Active profiles mismatch (-got +want):\n%s", diff)
+		}
+		if mix, found := conf.ActiveMixName(); !found || mix != "oncall" {
+			t.Fatalf("Expected active mix 'oncall', got: '%s' (found: %v)", mix, found)
+		}
+
+		// a mix assigning every path to one profile is reported by its name, by the prompt and status alike
+		rawConf.Mixes["all-work"] = map[string]string{"dir1": "work", "dir2": "work"}
+		internal.SaveGlobalConfRaw(rawConf)
+		runCmd(t, "profs", "set", "all-work")
+		status := runJSON[internal.StatusProfileResult](t, "profs", "status-profile")
+		if state := internal.StateOf(internal.LoadGlobalConf()); state.Profile != "all-work" || status.Profile != "all-work" || status.State != "mix" {
+			t.Fatalf("Expected mix 'all-work' to be reported, got state %+v and status %+v", state, status)
+		}
+	})
+}
+
+func TestMissingSlotPolicy(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+
+	setWithPolicy := func(t *testing.T, policy string) error {
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.MissingSlot = map[string]string{dirToAdd2: policy}
+		internal.SaveGlobalConfRaw(rawConf)
+		os.Args = []string{"profs", "set", "client-a"}
+		return mainCmd().RunE()
+	}
+
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "add-profile", "client-a"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+		deleteDirAndContents(filepath.Join(dirToAdd2+".profs", "client-a"))
+
+		func() {
+			defer func() {
+				expectPanic(t, recover(), nil, "has no slot for path")
+			}()
+			_ = setWithPolicy(t, "fail")
+		}()
+		if diff := cmp.Diff(internal.LoadGlobalConf().ActiveProfileNames(), []string{"work"}); diff != "" {
+			t.Fatalf("Expected a failed switch to leave all paths untouched (-got +want):\n%s", diff)
+		}
+
+		if err := setWithPolicy(t, "fallback:work"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		conf := internal.LoadGlobalConf()
+		if conf.Paths[0].ResolvedTgt.Name != "client-a" || conf.Paths[1].ResolvedTgt.Name != "work" {
+			t.Fatalf("Expected fallback to work for '%s', got: %v", dirToAdd2, conf.Paths)
+		}
+
+		// a partial set records the fallback profile a path got
+		os.Args = []string{"profs", "set", "work"}
+		if err := mainCmd().RunE(); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		os.Args = []string{"profs", "set", "client-a", "--path", dirToAdd1, "--path", dirToAdd2}
+		if err := mainCmd().RunE(); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		conf = internal.LoadGlobalConf()
+		if conf.Paths[1].ResolvedTgt.Name != "work" || conf.Paths[1].OverrideProfile != "" || !conf.IsIntendedMixedState() {
+			t.Fatalf("Expected fallback to work for '%s' without drift, got: %v", dirToAdd2, conf.Paths)
+		}
+
+		if err := setWithPolicy(t, "empty"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		conf = internal.LoadGlobalConf()
+		if conf.Paths[1].Status != internal.StatusEmpty || !conf.AllProfilesResolved() {
+			t.Fatalf("Expected '%s' to point to an empty placeholder, got: %v", dirToAdd2, conf.Paths[1])
+		}
+		if diff := cmp.Diff(conf.DetectedProfileNames(), []string{"client-a", "work"}); diff != "" {
+			t.Fatalf("Expected placeholder not to be detected as a profile (-got +want):\n%s", diff)
+		}
+
+		// other entries starting with a dot are still profiles
+		mkDir(dirToAdd1+".profs", ".old")
+		if diff := cmp.Diff(internal.LoadGlobalConf().DetectedProfileNames(), []string{".old", "client-a", "work"}); diff != "" {
+			t.Fatalf("Expected dot-prefixed profile to be detected (-got +want):\n%s", diff)
+		}
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "reserved") }()
+			os.Args = []string{"profs", "add-profile", ".empty"}
+			_ = mainCmd().RunE()
+		}()
+	})
+}
+
+func TestAutoSelectFromMarkerFile(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	workspace := mkDir(testDir, "src", "client-a")
+	subDir := mkDir(workspace, "repo", "sub")
+	if err := os.WriteFile(filepath.Join(workspace, ".profs-profile"), []byte("# client work\nclient-a\n"), 0644); err != nil {
+		t.Fatalf("Failed to write marker file: %v", err)
+	}
+
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "client-a"},
+		{"profs", "auto", testDir},
+		{"profs", "auto", subDir},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		conf := internal.LoadGlobalConf()
+		if diff := cmp.Diff(conf.ActiveProfileNames(), []string{"client-a"}); diff != "" {
+			t.Fatalf("Active profiles mismatch (-got +want):\n%s", diff)
+		}
+	})
+}
+
+func TestGuardWithRemoteRules(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	repo := mkDir(testDir, "repo")
+	mkDir(repo, ".git")
+	gitConfig := "[core]\n\tbare = false\n[remote \"origin\"]\n\turl = git@github.com:acme-corp/service.git\n"
+	if err := os.WriteFile(filepath.Join(repo, ".git", "config"), []byte(gitConfig), 0644); err != nil {
+		t.Fatalf("Failed to write git config: %v", err)
+	}
+
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "personal"},
+		{"profs", "add-profile", "work"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.Rules = []internal.RemoteRule{{Remote: "github.com/acme-corp/*", Profile: "work"}}
+		internal.SaveGlobalConfRaw(rawConf)
+
+		selection, found, err := internal.LoadGlobalConf().SelectProfileForDir(repo)
+		if err != nil || !found || selection.Profile != "work" {
+			t.Fatalf("Expected rule to select 'work', got: %v (found: %v, err: %v)", selection, found, err)
+		}
+
+		func() {
+			defer func() {
+				expectPanic(t, recover(), nil, "this repository requires profile 'work'")
+			}()
+			os.Args = []string{"profs", "guard", repo}
+			_ = mainCmd().RunE()
+		}()
+
+		os.Args = []string{"profs", "set", "work"}
+		if err := mainCmd().RunE(); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		os.Args = []string{"profs", "guard", repo}
+		if err := mainCmd().RunE(); err != nil {
+			t.Fatalf("Expected guard to pass, got: %v", err)
+		}
+	})
+}
+
+func TestGuardInstall(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found on PATH")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	repo := mkDir(testDir, "repo")
+	worktree := filepath.Join(testDir, "worktree")
+	git := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "--quiet")
+	git("-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "--quiet", "--allow-empty", "-m", "init")
+	git("worktree", "add", "--quiet", worktree)
+
+	runTest(t, [][]string{
+		{"profs", "guard", "--install", worktree},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		// git reads the hooks of a worktree from the main repository
+		if _, err := os.Stat(filepath.Join(repo, ".git", "hooks", "pre-commit")); err != nil {
+			t.Fatalf("Expected the hook in the main repository: %v", err)
+		}
+
+		git("config", "core.hooksPath", ".githooks")
+		runCmd(t, "profs", "guard", "--install", repo)
+		if _, err := os.Stat(filepath.Join(repo, ".githooks", "pre-push")); err != nil {
+			t.Fatalf("Expected the hook in core.hooksPath: %v", err)
+		}
+	})
+}
+
+func TestStateFile(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+		{"profs", "set", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		state, err := internal.LoadState()
+		if err != nil {
+			t.Fatalf("Expected state file to be written, got: %v", err)
+		}
+		if state.DisplayName() != "personal" || state.Drift || !state.Verify() {
+			t.Fatalf("Expected state for 'personal' without drift, got: %+v", state)
+		}
+
+		// changes made behind the back of profs are caught by verification
+		if err := os.Remove(dirToAdd1); err != nil {
+			t.Fatalf("Failed to remove symlink: %v", err)
+		}
+		if err := os.Symlink(filepath.Join(dirToAdd1+".profs", "work"), dirToAdd1); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		if state.Verify() {
+			t.Fatalf("Expected verification to detect drift")
+		}
+	})
+}
+
+func TestBrokenConfig(t *testing.T) {
+	runTest(t, [][]string{{"profs", "list"}}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		if err := os.WriteFile(internal.GlobalConfigPath(), []byte("{ not json"), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+
+		run := func(args ...string) (pan any, err error) {
+			defer func() { pan = recover() }()
+			os.Args = args
+			return nil, mainCmd().RunE()
+		}
+
+		// commands not needing the config still work
+		for _, args := range [][]string{{"profs", "--help"}, {"profs", "hook", "bash"}, {"profs", "prompt"}} {
+			pan, err := run(args...)
+			checkNoFailures(t, pan, err)
+		}
+
+		// commands needing it fail with a hint instead of a raw panic
+		pan, err = run("profs", "list")
+		expectPanic(t, pan, err, "Run 'profs doctor' or 'profs status-config' to diagnose")
+
+		pan, err = run("profs", "doctor")
+		expectPanic(t, pan, err, "run 'profs reset' to start over")
+
+		pan, err = run("profs", "status-config")
+		expectPanic(t, pan, err, "code: 4, msg: failed to parse global config")
+
+		// commands changing the config exit with the config error code too, instead of crashing
+		pan, err = run("profs", "remove", "~/.x", "-y")
+		expectPanic(t, pan, err, "code: 4, msg: failed to parse global config")
+
+		// doctor still checks the paths it can read from a config that fails to load
+		testDir := mkTempDir()
+		defer func() { deleteDirAndContents(testDir) }()
+		missingPath := filepath.Join(testDir, "missing")
+		if err := os.WriteFile(internal.GlobalConfigPath(), []byte(`{"paths": ["`+missingPath+`"], "colors": 5}`), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		out := captureStdout(t, func() { pan, err = run("profs", "doctor") })
+		expectPanic(t, pan, err, "code: 4")
+		if !strings.Contains(out, "Source path "+missingPath+" does not exist") {
+			t.Fatalf("Expected doctor to check the readable paths, got:\n%s", out)
+		}
+	})
+}
+
+func TestBrokenPathsAreReported(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	dirToAdd3 := mkDir(testDir, "dir3")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "add", dirToAdd3},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		// dir1: the active slot is a symlink loop
+		loopSlot := filepath.Join(dirToAdd1+".profs", "work")
+		deleteDirAndContents(loopSlot)
+		if err := os.Symlink(loopSlot, loopSlot); err != nil {
+			t.Fatalf("Failed to create symlink loop: %v", err)
+		}
+		// dir2: the storage directory is gone
+		deleteDirAndContents(dirToAdd2 + ".profs")
+		// dir3: switched to a file slot while the other slot is a directory
+		fileSlot := filepath.Join(dirToAdd3+".profs", "other")
+		if err := os.WriteFile(fileSlot, []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write slot: %v", err)
+		}
+		if err := os.Remove(dirToAdd3); err != nil {
+			t.Fatalf("Failed to remove symlink: %v", err)
+		}
+		if err := os.Symlink(fileSlot, dirToAdd3); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+
+		statuses := lo.Map(internal.LoadGlobalConf().Paths, func(p internal.Path, _ int) internal.Status { return p.Status })
+		wantStatuses := []internal.Status{internal.StatusErrorSymlinkLoop, internal.StatusErrorStorageNotFound, internal.StatusErrorWrongSlotType}
+		if diff := cmp.Diff(statuses, wantStatuses); diff != "" {
+			t.Fatalf("Statuses mismatch (-got +want):\n%s", diff)
+		}
+
+		for _, args := range [][]string{{"profs", "status"}, {"profs", "status-full"}} {
+			os.Args = args
+			if err := mainCmd().RunE(); err != nil {
+				t.Fatalf("Expected %v to report broken paths without failing, got: %v", args, err)
+			}
+		}
+
+		os.Args = []string{"profs", "doctor"}
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "Found 3 inconsistencies") }()
+			_ = mainCmd().RunE()
+		}()
+	})
+}
+
+func TestMachineReadableOutput(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		set := runJSON[internal.SetResult](t, "profs", "set", "personal")
+		if set.Kind != "set" || set.SchemaVersion != 1 || len(set.Paths) != 1 || set.Paths[0].Profile != "personal" {
+			t.Fatalf("Unexpected set result: %+v", set)
+		}
+
+		status := runJSON[internal.StatusResult](t, "profs", "status")
+		wantPaths := []internal.PathResult{{
+			Path:       dirToAdd1,
+			Profile:    "personal",
+			Target:     filepath.Join(dirToAdd1+".profs", "personal"),
+			StorageDir: dirToAdd1 + ".profs",
+			Status:     internal.StatusOk,
+		}}
+		if diff := cmp.Diff(status.Paths, wantPaths); status.Profile != "personal" || status.Drift || diff != "" {
+			t.Fatalf("Unexpected status result: %+v\n%s", status, diff)
+		}
+
+		list := runCmd(t, "profs", "list", "-o", "yaml")
+		for _, want := range []string{"kind: list\n", "schemaVersion: 1\n", "  - name: personal\n    active: true\n", "  - name: work\n    active: false\n"} {
+			if !strings.Contains(list, want) {
+				t.Fatalf("Expected list output to contain %q, got:\n%s", want, list)
+			}
+		}
+
+		doctor := runJSON[internal.DoctorResult](t, "profs", "doctor")
+		if doctor.Kind != "doctor" || doctor.Warnings != 0 {
+			t.Fatalf("Unexpected doctor result: %+v", doctor)
+		}
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+		{"profs", "add", dirToAdd2, "--dry-run"},
+		{"profs", "add-profile", "other", "--dry-run"},
+		{"profs", "set", "personal", "--dry-run"},
+		{"profs", "remove-profile", "personal", "--dry-run"},
+		{"profs", "remove", dirToAdd1, "--dry-run"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		conf := internal.LoadGlobalConf()
+		if len(conf.Paths) != 1 || conf.Paths[0].SrcPath != dirToAdd1 {
+			t.Fatalf("Expected config to be unchanged, got paths: %v", conf.Paths)
+		}
+		if diff := cmp.Diff(conf.DetectedProfileNames(), []string{"personal", "work"}); diff != "" {
+			t.Fatalf("Profiles mismatch (-got +want):\n%s", diff)
+		}
+		if diff := cmp.Diff(conf.ActiveProfileNames(), []string{"work"}); diff != "" {
+			t.Fatalf("Active profiles mismatch (-got +want):\n%s", diff)
+		}
+		if stat, err := os.Lstat(dirToAdd2); err != nil || !stat.IsDir() {
+			t.Fatalf("Expected %s to still be a plain directory, got: %v", dirToAdd2, err)
+		}
+
+		os.Args = []string{"profs", "remove-profile", "personal", "--dry-run"}
+		out := captureStdout(t, func() { _ = mainCmd().RunE() })
+		if want := "mv " + filepath.Join(dirToAdd1+".profs", "personal") + " " + internal.TrashDir(); !strings.Contains(out, want) {
+			t.Fatalf("Expected planned operations to contain %q, got:\n%s", want, out)
+		}
+	})
+}
+
+func TestTrashRestore(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	slot := filepath.Join(dirToAdd1+".profs", "personal")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		if err := os.WriteFile(filepath.Join(slot, "credentials"), []byte("secret"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.Mixes = map[string]map[string]string{"weekend": {dirToAdd1: "personal"}}
+		rawConf.MissingSlot = map[string]string{dirToAdd1: "fallback:personal"}
+		rawConf.Rules = []internal.RemoteRule{{Remote: "github.com/acme-corp/*", Profile: "work"}, {Remote: "github.com/me/*", Profile: "personal"}}
+		rawConf.Colors = map[string]string{"personal": "green", "work": "blue"}
+		internal.SaveGlobalConfRaw(rawConf)
+
+		runCmd(t, "profs", "remove-profile", "personal", "--yes")
+		rawConf = internal.LoadGlobalConfRaw()
+		if rawConf.Mixes != nil || rawConf.MissingSlot != nil || len(rawConf.Rules) != 1 || len(rawConf.Colors) != 1 {
+			t.Fatalf("Expected all references to 'personal' to be removed from the config, got: %+v", rawConf)
+		}
+		if _, err := os.Stat(slot); !os.IsNotExist(err) {
+			t.Fatalf("Expected slot %s to be removed, got: %v", slot, err)
+		}
+		entries, err := internal.ListTrash()
+		if err != nil || len(entries) != 1 || entries[0].Profile != "personal" {
+			t.Fatalf("Expected 'personal' in the trash, got: %+v, %v", entries, err)
+		}
+
+		runCmd(t, "profs", "trash", "restore", "personal")
+		if bytes, err := os.ReadFile(filepath.Join(slot, "credentials")); err != nil || string(bytes) != "secret" {
+			t.Fatalf("Expected restored slot to keep its content, got: %q, %v", bytes, err)
+		}
+		if entries, _ := internal.ListTrash(); len(entries) != 0 {
+			t.Fatalf("Expected trash to be empty after restoring, got: %+v", entries)
+		}
+		rawConf = internal.LoadGlobalConfRaw()
+		if rawConf.Mixes["weekend"][dirToAdd1] != "personal" || rawConf.MissingSlot[dirToAdd1] != "fallback:personal" ||
+			len(rawConf.Rules) != 2 || rawConf.Rules[1].Profile != "personal" || rawConf.Colors["personal"] != "green" {
+			t.Fatalf("Expected the references to 'personal' to be restored, got: %+v", rawConf)
+		}
+
+		runCmd(t, "profs", "remove-profile", "personal", "--yes")
+		runCmd(t, "profs", "trash", "empty", "--older-than", "30d", "--yes")
+		if entries, _ := internal.ListTrash(); len(entries) != 1 {
+			t.Fatalf("Expected recently removed profile to be kept, got: %+v", entries)
+		}
+		runCmd(t, "profs", "trash", "empty", "--yes")
+		if entries, _ := internal.ListTrash(); len(entries) != 0 {
+			t.Fatalf("Expected trash to be empty, got: %+v", entries)
+		}
+	})
+}
+
+func TestRemoveProfileFromPath(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2, "--profile", "work"},
+		{"profs", "add-profile", "client-a"},
+		{"profs", "remove-profile", "client-a", "--path", dirToAdd2, "--yes"},
+		{"profs", "doctor"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		if _, err := os.Stat(filepath.Join(dirToAdd1+".profs", "client-a")); err != nil {
+			t.Fatalf("Expected slot of dir1 to be kept: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dirToAdd2+".profs", "client-a")); !os.IsNotExist(err) {
+			t.Fatalf("Expected slot of dir2 to be removed, got: %v", err)
+		}
+		rawConf := internal.LoadGlobalConfRaw()
+		if len(rawConf.Sparse["client-a"]) != 1 {
+			t.Fatalf("Expected dir2 to be recorded as sparse for client-a, got: %v", rawConf.Sparse)
+		}
+
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "it is active for that path") }()
+			os.Args = []string{"profs", "remove-profile", "work", "--path", dirToAdd1, "--yes"}
+			_ = mainCmd().RunE()
+		}()
+	})
+}
+
+func TestDeclaredProfilePaths(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+		{"profs", "add", dirToAdd2, "--profile", "work", "--skip-profile", "personal"},
+		{"profs", "add-profile", "client-a", "--skip-path", dirToAdd1},
+		{"profs", "set", "personal"},
+		{"profs", "doctor"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		for _, slot := range []string{filepath.Join(dirToAdd2+".profs", "personal"), filepath.Join(dirToAdd1+".profs", "client-a")} {
+			if _, err := os.Stat(slot); !os.IsNotExist(err) {
+				t.Fatalf("Expected no slot %s for a profile not managing the path, got: %v", slot, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dirToAdd2+".profs", "client-a")); err != nil {
+			t.Fatalf("Expected slot of client-a for dir2: %v", err)
+		}
+
+		gc := internal.LoadGlobalConf()
+		active := lo.Map(gc.Paths, func(p internal.Path, _ int) string { return p.ResolvedTgt.Name })
+		if diff := cmp.Diff(active, []string{"personal", "work"}); diff != "" {
+			t.Fatalf("Expected dir2 to stay on work (-got +want):\n%s", diff)
+		}
+		state := internal.StateOf(gc)
+		if state.Profile != "personal" || state.Drift {
+			t.Fatalf("Expected profile 'personal' without drift, got: %+v", state)
+		}
+
+		// dir2 being on work doesn't make personal inactive for guard and auto
+		if !gc.IsActive("personal") || gc.IsActive("work") {
+			t.Fatalf("Expected only personal to be active, got: %v", gc.Paths)
+		}
+		if err := os.WriteFile(filepath.Join(testDir, ".profs-profile"), []byte("personal\n"), 0644); err != nil {
+			t.Fatalf("Failed to write marker file: %v", err)
+		}
+		runCmd(t, "profs", "guard", testDir)
+		before, err := internal.LoadState()
+		if err != nil {
+			t.Fatalf("Failed to load state: %v", err)
+		}
+		runCmd(t, "profs", "auto", testDir)
+		if after, err := internal.LoadState(); err != nil || !after.UpdatedAt.Equal(before.UpdatedAt) {
+			t.Fatalf("Expected auto not to switch again, got: %+v, %v", after, err)
+		}
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	archive := filepath.Join(testDir, "work.tar.zst")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "add-profile", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		if err := os.MkdirAll(filepath.Join(dirToAdd1, "keys"), 0700); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dirToAdd1, "keys", "id_ed25519"), []byte("secret"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		runCmd(t, "profs", "export", "work", "-f", archive)
+		runCmd(t, "profs", "import", archive, "--as", "work2")
+
+		imported := filepath.Join(dirToAdd1+".profs", "work2", "keys", "id_ed25519")
+		if bytes, err := os.ReadFile(imported); err != nil || string(bytes) != "secret" {
+			t.Fatalf("Expected imported slot to keep its content, got: %q, %v", bytes, err)
+		}
+		if info, err := os.Stat(imported); err != nil || info.Mode().Perm() != 0600 {
+			t.Fatalf("Expected imported file to keep its mode, got: %v, %v", info, err)
+		}
+		if _, err := os.Stat(filepath.Join(dirToAdd2+".profs", "work2")); err != nil {
+			t.Fatalf("Expected imported slot for dir2: %v", err)
+		}
+
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "already exists") }()
+			runCmd(t, "profs", "import", archive)
+		}()
+
+		// the archive doesn't get to choose where it is extracted to
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "invalid profile name") }()
+			runCmd(t, "profs", "import", archive, "--as", "../work3")
+		}()
+
+		// a file changed after export fails its checksum
+		tampered := filepath.Join(testDir, "tampered.tar.zst")
+		rewriteArchive(t, archive, tampered, func(name string, data []byte) []byte {
+			if strings.HasSuffix(name, "id_ed25519") {
+				return []byte("SECRET")
+			}
+			return data
+		})
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "checksum") }()
+			runCmd(t, "profs", "import", tampered, "--as", "work3")
+		}()
+		if _, err := os.Stat(filepath.Join(dirToAdd1+".profs", "work3")); !os.IsNotExist(err) {
+			t.Fatalf("Expected nothing to be imported from a tampered archive, got: %v", err)
+		}
+
+		// a corrupted archive is rejected
+		bytes, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		if err := os.WriteFile(archive, bytes[:len(bytes)/2], 0600); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "Failed to import") }()
+			runCmd(t, "profs", "import", archive, "--as", "work3")
+		}()
+		if _, err := os.Stat(filepath.Join(dirToAdd1+".profs", "work3")); !os.IsNotExist(err) {
+			t.Fatalf("Expected nothing to be imported from a corrupted archive, got: %v", err)
+		}
+	})
+}
+
+func TestImportOnNewMachine(t *testing.T) {
+	home := mkTempDir()
+	defer func() { deleteDirAndContents(home) }()
+	t.Setenv("HOME", home)
+
+	dirToAdd1 := mkDir(home, ".dir1")
+	dirToAdd2 := mkDir(home, ".dir2", "sub")
+	archive := filepath.Join(home, "work.tar.zst")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		if err := os.WriteFile(filepath.Join(dirToAdd1, "config"), []byte("work"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		runCmd(t, "profs", "export", "work", "-f", archive)
+
+		// a new machine has neither the config nor the managed paths
+		for _, p := range []string{internal.GlobalConfigPath(), internal.StateFilePath(), dirToAdd1, dirToAdd1 + ".profs", filepath.Dir(dirToAdd2)} {
+			if err := os.RemoveAll(p); err != nil {
+				t.Fatalf("Failed to remove %s: %v", p, err)
+			}
+		}
+
+		// a path that can't be placed, here because the archive has it twice, rolls back the paths placed before it
+		broken := filepath.Join(home, "broken.tar.zst")
+		rewriteArchive(t, archive, broken, func(name string, data []byte) []byte {
+			return lo.Ternary(name == "manifest.json", []byte(strings.ReplaceAll(string(data), "~/.dir2/sub", "~/.dir1")), data)
+		})
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "rolled back") }()
+			runCmd(t, "profs", "import", broken)
+		}()
+		for _, p := range []string{dirToAdd1, dirToAdd1 + ".profs"} {
+			if _, err := os.Lstat(p); !os.IsNotExist(err) {
+				t.Fatalf("Expected %s to be rolled back, got: %v", p, err)
+			}
+		}
+		if paths := internal.LoadGlobalConf().Paths; len(paths) != 0 {
+			t.Fatalf("Expected no paths to be added, got: %+v", paths)
+		}
+
+		runCmd(t, "profs", "import", archive)
+
+		if bytes, err := os.ReadFile(filepath.Join(dirToAdd1, "config")); err != nil || string(bytes) != "work" {
+			t.Fatalf("Expected the imported profile to be active, got: %q, %v", bytes, err)
+		}
+		gc := internal.LoadGlobalConf()
+		if len(gc.Paths) != 2 || gc.Paths[0].ResolvedTgt == nil || gc.Paths[0].ResolvedTgt.Name != "work" {
+			t.Fatalf("Expected the imported path to be managed with profile work, got: %+v", gc.Paths)
+		}
+	})
+}
+
+func TestEncryptedExportImport(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		if err := os.WriteFile(filepath.Join(dirToAdd1, "token"), []byte("secret"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("Failed to generate identity: %v", err)
+		}
+		identityFile := filepath.Join(testDir, "keys.txt")
+		if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+			t.Fatalf("Failed to write identity: %v", err)
+		}
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.Encryption = &internal.EncryptionConfig{Recipients: []string{identity.Recipient().String()}, Identities: []string{identityFile}}
+		internal.SaveGlobalConfRaw(rawConf)
+
+		// to the configured recipients
+		archive := filepath.Join(testDir, "work.tar.zst.age")
+		runCmd(t, "profs", "export", "work", "--encrypt", "-f", archive)
+		if bytes, err := os.ReadFile(archive); err != nil || !strings.HasPrefix(string(bytes), "age-encryption.org/") || strings.Contains(string(bytes), "manifest.json") {
+			t.Fatalf("Expected an age encrypted archive, got: %v", err)
+		}
+		runCmd(t, "profs", "import", archive, "--as", "work2")
+		if bytes, err := os.ReadFile(filepath.Join(dirToAdd1+".profs", "work2", "token")); err != nil || string(bytes) != "secret" {
+			t.Fatalf("Expected decrypted slot content, got: %q, %v", bytes, err)
+		}
+
+		// with a passphrase
+		t.Setenv("PROFS_PASSPHRASE", "correct horse")
+		archive = filepath.Join(testDir, "work-passphrase.tar.zst.age")
+		runCmd(t, "profs", "export", "work", "--passphrase", "-f", archive)
+		t.Setenv("PROFS_PASSPHRASE", "wrong")
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "Failed to import") }()
+			runCmd(t, "profs", "import", archive, "--as", "work3")
+		}()
+		t.Setenv("PROFS_PASSPHRASE", "correct horse")
+		runCmd(t, "profs", "import", archive, "--as", "work3")
+		if _, err := os.Stat(filepath.Join(dirToAdd1+".profs", "work3", "token")); err != nil {
+			t.Fatalf("Expected slot imported with a passphrase: %v", err)
+		}
+	})
+}
+
+func TestLockUnlock(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	dirToAdd2 := mkDir(testDir, "dir2")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add", dirToAdd2},
+		{"profs", "add-profile", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		storageDir := dirToAdd1 + ".profs"
+		if err := os.WriteFile(filepath.Join(storageDir, "personal", "token"), []byte("secret"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("Failed to generate identity: %v", err)
+		}
+		identityFile := filepath.Join(testDir, "keys.txt")
+		if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+			t.Fatalf("Failed to write identity: %v", err)
+		}
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.Encryption = &internal.EncryptionConfig{Recipients: []string{identity.Recipient().String()}, Identities: []string{identityFile}}
+		internal.SaveGlobalConfRaw(rawConf)
+
+		expectLocked := func(profile string, locked bool) {
+			t.Helper()
+			_, errSlot := os.Stat(filepath.Join(storageDir, profile))
+			_, errSealed := os.Stat(filepath.Join(storageDir, "."+profile+".sealed"))
+			if locked != (errSlot != nil) || locked != (errSealed == nil) {
+				t.Fatalf("Expected profile %s locked: %v, got slot error %v and sealed error %v", profile, locked, errSlot, errSealed)
+			}
+		}
+
+		runCmd(t, "profs", "lock")
+		expectLocked("personal", true)
+		expectLocked("work", false)
+		if diff := cmp.Diff(internal.LoadGlobalConf().DetectedProfileNames(), []string{"personal", "work"}); diff != "" {
+			t.Fatalf("Expected locked profile to still be detected (-got +want):\n%s", diff)
+		}
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "is locked") }()
+			runCmd(t, "profs", "remove-profile", "personal", "--yes")
+		}()
+
+		// a slot that can't be unlocked aborts the switch before any path is touched
+		sealed2 := filepath.Join(dirToAdd2+".profs", ".personal.sealed")
+		sealedBytes, err := os.ReadFile(sealed2)
+		if err != nil {
+			t.Fatalf("Failed to read sealed slot: %v", err)
+		}
+		if err := os.WriteFile(sealed2, []byte("garbage"), 0600); err != nil {
+			t.Fatalf("Failed to write sealed slot: %v", err)
+		}
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "Failed to unlock profile personal") }()
+			runCmd(t, "profs", "set", "personal")
+		}()
+		expectLocked("personal", true)
+		if target, err := os.Readlink(dirToAdd1); err != nil || filepath.Base(target) != "work" {
+			t.Fatalf("Expected dir1 to stay on work, got: %s, %v", target, err)
+		}
+		if err := os.WriteFile(sealed2, sealedBytes, 0600); err != nil {
+			t.Fatalf("Failed to write sealed slot: %v", err)
+		}
+
+		// switching unlocks the target, and with sealInactive locks the previous profile
+		rawConf = internal.LoadGlobalConfRaw()
+		rawConf.Encryption.SealInactive = true
+		internal.SaveGlobalConfRaw(rawConf)
+		runCmd(t, "profs", "set", "personal")
+		expectLocked("personal", false)
+		expectLocked("work", true)
+		if state := internal.StateOf(internal.LoadGlobalConf()); state.Profile != "personal" || state.Drift {
+			t.Fatalf("Expected the switch to be recorded, got: %+v", state)
+		}
+		if bytes, err := os.ReadFile(filepath.Join(dirToAdd1, "token")); err != nil || string(bytes) != "secret" {
+			t.Fatalf("Expected unlocked slot content, got: %q, %v", bytes, err)
+		}
+
+		runCmd(t, "profs", "unlock")
+		expectLocked("work", false)
+	})
+}
+
+func TestSignedExportImport(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "baseline"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		newKey := func(name string) (string, string) {
+			publicKey, privateKey, err := ed25519.GenerateKey(nil)
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			block, err := ssh.MarshalPrivateKey(privateKey, "")
+			if err != nil {
+				t.Fatalf("Failed to marshal key: %v", err)
+			}
+			keyFile := filepath.Join(testDir, name)
+			if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+				t.Fatalf("Failed to write key: %v", err)
+			}
+			sshKey, err := ssh.NewPublicKey(publicKey)
+			if err != nil {
+				t.Fatalf("Failed to convert key: %v", err)
+			}
+			return keyFile, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey)))
+		}
+		platformKey, platformPub := newKey("platform")
+		_, otherPub := newKey("other")
+
+		signed := filepath.Join(testDir, "signed.tar.zst")
+		unsigned := filepath.Join(testDir, "unsigned.tar.zst")
+		runCmd(t, "profs", "export", "baseline", "--sign", platformKey, "-f", signed)
+		runCmd(t, "profs", "export", "baseline", "-f", unsigned)
+
+		runCmd(t, "profs", "import", signed, "--as", "baseline2", "--require-signer", platformPub)
+		if _, err := os.Stat(filepath.Join(dirToAdd1+".profs", "baseline2")); err != nil {
+			t.Fatalf("Expected signed archive to be imported: %v", err)
+		}
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "which is not a trusted signer") }()
+			runCmd(t, "profs", "import", signed, "--as", "baseline3", "--require-signer", otherPub)
+		}()
+
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.TrustedSigners = []string{platformPub}
+		internal.SaveGlobalConfRaw(rawConf)
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "archive is not signed") }()
+			runCmd(t, "profs", "import", unsigned, "--as", "baseline3")
+		}()
+		runCmd(t, "profs", "import", signed, "--as", "baseline3")
+	})
+}
+
+func TestVersioning(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found on PATH")
+	}
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		storageDir := dirToAdd1 + ".profs"
+		configFile := filepath.Join(storageDir, "work", "config")
+		writeConfig := func(content string) {
+			if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "enable versioning") }()
+			runCmd(t, "profs", "history", dirToAdd1)
+		}()
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.Versioning = true
+		internal.SaveGlobalConfRaw(rawConf)
+
+		// versioning would keep locked slots in plaintext
+		rawConf.Encryption = &internal.EncryptionConfig{SealInactive: true}
+		internal.SaveGlobalConfRaw(rawConf)
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "can't be combined with encryption.sealInactive") }()
+			runCmd(t, "profs", "status")
+		}()
+		rawConf.Encryption = nil
+		internal.SaveGlobalConfRaw(rawConf)
+
+		// the user's git hooks don't run on commits of slots, where they could block or leak them
+		hooksDir := mkDir(testDir, "hooks")
+		if err := os.WriteFile(filepath.Join(hooksDir, "pre-commit"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+			t.Fatalf("Failed to write hook: %v", err)
+		}
+		gitConfig := filepath.Join(testDir, "gitconfig")
+		if err := os.WriteFile(gitConfig, []byte("[core]\n\thooksPath = "+hooksDir+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write git config: %v", err)
+		}
+		t.Setenv("GIT_CONFIG_GLOBAL", gitConfig)
+
+		// switching away from a profile records its content
+		keyFile := filepath.Join(storageDir, "work", "id_ed25519")
+		if err := os.WriteFile(keyFile, []byte("key"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		writeConfig("v1")
+		runCmd(t, "profs", "set", "personal")
+		writeConfig("v2")
+		runCmd(t, "profs", "set", "work")
+		runCmd(t, "profs", "set", "personal")
+
+		history := runJSON[internal.HistoryResult](t, "profs", "history", dirToAdd1, "--profile", "work")
+		if diff := cmp.Diff(lo.Map(history.Entries, func(e internal.HistoryEntryResult, _ int) string { return e.Message }), []string{
+			"Switch " + dirToAdd1 + " from personal to work",
+			"Switch " + dirToAdd1 + " from work to personal",
+		}); diff != "" {
+			t.Fatalf("Unexpected history (-got +want):\n%s", diff)
+		}
+
+		runCmd(t, "profs", "restore", dirToAdd1, "--profile", "work", "--at", history.Entries[1].Rev)
+		if bytes, err := os.ReadFile(configFile); err != nil || string(bytes) != "v1" {
+			t.Fatalf("Expected restored content, got: %q, %v", bytes, err)
+		}
+		if stat, err := os.Stat(keyFile); err != nil || stat.Mode().Perm() != 0600 {
+			t.Fatalf("Expected the restored key to keep its permissions, got: %v, %v", stat, err)
+		}
+		history = runJSON[internal.HistoryResult](t, "profs", "history", dirToAdd1, "--profile", "work")
+		if len(history.Entries) != 3 || !strings.HasPrefix(history.Entries[0].Message, "Restore work to ") {
+			t.Fatalf("Expected the restore to be recorded, got: %+v", history.Entries)
+		}
+	})
+}
+
+func TestSnapshots(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		storageDir := dirToAdd1 + ".profs"
+		configFile := filepath.Join(storageDir, "personal", "config")
+		if err := os.WriteFile(configFile, []byte("v1"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		first := runJSON[internal.SnapshotCreateResult](t, "profs", "snapshot", "create", "-m", "first")
+		if first.Slots != 2 {
+			t.Fatalf("Expected 2 slots in snapshot, got: %+v", first)
+		}
+
+		if err := os.WriteFile(configFile, []byte("v2"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		rawConf := internal.LoadGlobalConfRaw()
+		rawConf.AutoSnapshot = true
+		internal.SaveGlobalConfRaw(rawConf)
+		runCmd(t, "profs", "remove-profile", "personal", "--yes")
+
+		list := runJSON[internal.SnapshotListResult](t, "profs", "snapshot", "list")
+		if len(list.Snapshots) != 2 || list.Snapshots[1].Message != "Before remove-profile personal" {
+			t.Fatalf("Expected an automatic snapshot before removing the profile, got: %+v", list.Snapshots)
+		}
+		second := list.Snapshots[1].ID
+
+		diff := runJSON[internal.SnapshotDiffResult](t, "profs", "snapshot", "diff", first.ID, second)
+		if d := cmp.Diff(diff.Changes, []internal.SnapshotChange{{Path: dirToAdd1, Profile: "personal", File: "config", Change: "changed"}}); d != "" {
+			t.Fatalf("Unexpected snapshot diff (-got +want):\n%s", d)
+		}
+
+		// objects are shared between snapshots with the same content
+		objects, err := filepath.Glob(filepath.Join(internal.SnapshotsDir(), "objects", "*", "*"))
+		if err != nil || len(objects) != 4 {
+			t.Fatalf("Expected 4 objects (2 config versions, 2 config files), got: %v, %v", objects, err)
+		}
+
+		runCmd(t, "profs", "snapshot", "restore", first.ID, "--profile", "personal")
+		if bytes, err := os.ReadFile(configFile); err != nil || string(bytes) != "v1" {
+			t.Fatalf("Expected restored content, got: %q, %v", bytes, err)
+		}
+		if diff := cmp.Diff(internal.LoadGlobalConf().DetectedProfileNames(), []string{"personal", "work"}); diff != "" {
+			t.Fatalf("Expected restored profile to be detected (-got +want):\n%s", diff)
+		}
+
+		// an existing slot is replaced, also when it has read-only directories
+		readOnlyDir := mkDir(storageDir, "work", "keys")
+		if err := os.WriteFile(filepath.Join(readOnlyDir, "id_ed25519"), []byte("key"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Chmod(readOnlyDir, 0500); err != nil {
+			t.Fatalf("Failed to make directory read-only: %v", err)
+		}
+		runCmd(t, "profs", "snapshot", "restore", first.ID, "--profile", "work")
+		if _, err := os.Stat(readOnlyDir); !os.IsNotExist(err) {
+			t.Fatalf("Expected the slot to be replaced, got: %v", err)
+		}
+	})
+}
+
+func TestDiffProfiles(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		storageDir := dirToAdd1 + ".profs"
+		write := func(profile string, name string, content string) {
+			if err := os.WriteFile(filepath.Join(storageDir, profile, name), []byte(content), 0600); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+		write("work", "config", "a\nb\nc\ntoken: work-secret\n")
+		write("personal", "config", "a\nB\nc\ntoken: personal-secret\n")
+		write("work", "id_ed25519", "work key")
+		write("personal", "id_ed25519", "personal key")
+		write("personal", "extra", "new")
+
+		out := runCmd(t, "profs", "diff", "work", "personal", "-o", "json")
+		result := internal.DiffResult{}
+		if err := json.Unmarshal([]byte(out), &result); err != nil {
+			t.Fatalf("Failed to parse diff result: %v", err)
+		}
+		want := []internal.DiffPathResult{{Path: dirToAdd1, Files: []internal.DiffFileResult{
+			{File: "config", Change: "changed", Kind: "text", Diff: "--- work/config\n+++ personal/config\n@@ -1,4 +1,4 @@\n a\n-b\n+B\n c\n token: ***\n"},
+			{File: "extra", Change: "added", Kind: "text"},
+			{File: "id_ed25519", Change: "changed", Kind: "secret", Detail: "contents differ, masked"},
+		}}}
+		if diff := cmp.Diff(result.Paths, want); diff != "" {
+			t.Fatalf("Unexpected diff result (-got +want):\n%s", diff)
+		}
+		if strings.Contains(out, "work-secret") || strings.Contains(out, "work key") {
+			t.Fatalf("Expected secrets to be masked, got:\n%s", out)
+		}
+	})
+}
+
+func TestPropagate(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+		{"profs", "add-profile", "client"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		storageDir := dirToAdd1 + ".profs"
+		write := func(profile string, content string) {
+			if err := os.WriteFile(filepath.Join(storageDir, profile, "config"), []byte(content), 0600); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+		expectContent := func(profile string, want string) {
+			t.Helper()
+			if bytes, err := os.ReadFile(filepath.Join(storageDir, profile, "config")); err != nil || string(bytes) != want {
+				t.Fatalf("Expected %q in profile %s, got: %q, %v", want, profile, bytes, err)
+			}
+		}
+		statuses := func(result internal.PropagateResult) []string {
+			return lo.Map(result.Targets, func(r internal.PropagateTargetResult, _ int) string { return r.Profile + ":" + r.Status })
+		}
+
+		// without an earlier propagation, what differs in the target is kept
+		write("work", "[alias]\n  st = status\n[user]\n  email = work\n")
+		write("personal", "[alias]\n  st = status\n[user]\n  email = personal\n")
+		result := runJSON[internal.PropagateResult](t, "profs", "propagate", filepath.Join(dirToAdd1, "config"), "--to", "personal,client")
+		if diff := cmp.Diff(statuses(result), []string{"personal:unchanged", "client:created"}); diff != "" || result.Targets[0].Kept != 1 {
+			t.Fatalf("Unexpected propagation (-got +want):\n%s%+v", diff, result)
+		}
+
+		// later changes are merged against what was propagated before
+		write("work", "[alias]\n  st = status\n  co = checkout\n[user]\n  email = work\n")
+		result = runJSON[internal.PropagateResult](t, "profs", "propagate", filepath.Join(dirToAdd1, "config"), "--all")
+		if diff := cmp.Diff(statuses(result), []string{"client:updated", "personal:merged"}); diff != "" {
+			t.Fatalf("Unexpected propagation (-got +want):\n%s", diff)
+		}
+		expectContent("personal", "[alias]\n  st = status\n  co = checkout\n[user]\n  email = personal\n")
+
+		// changes to lines that differ in the target are conflicts, and not applied
+		write("work", "[alias]\n  st = status\n  co = checkout\n[user]\n  email = work2\n")
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "because of conflicts") }()
+			os.Args = []string{"profs", "propagate", filepath.Join(dirToAdd1, "config"), "--to", "personal"}
+			captureStdout(t, func() { _ = mainCmd().RunE() })
+		}()
+		expectContent("personal", "[alias]\n  st = status\n  co = checkout\n[user]\n  email = personal\n")
+
+		// without an earlier propagation, lines the source added next to a differing region are a conflict, not dropped
+		sshConfig := func(profile string, content string) {
+			if err := os.WriteFile(filepath.Join(storageDir, profile, "ssh_config"), []byte(content), 0600); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+		sshConfig("work", "Host a\n  User x\nHost new\n")
+		sshConfig("personal", "Host a\n  User y\n")
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "because of conflicts") }()
+			os.Args = []string{"profs", "propagate", filepath.Join(dirToAdd1, "ssh_config"), "--from", "work", "--to", "personal"}
+			captureStdout(t, func() { _ = mainCmd().RunE() })
+		}()
+		if bytes, err := os.ReadFile(filepath.Join(storageDir, "personal", "ssh_config")); err != nil || string(bytes) != "Host a\n  User y\n" {
+			t.Fatalf("Expected the conflicting target to be left as it was, got: %q, %v", bytes, err)
+		}
+	})
+}
+
+func TestGrepAndWhich(t *testing.T) {
+	testDir := mkTempDir()
+	defer func() { deleteDirAndContents(testDir) }()
+
+	dirToAdd1 := mkDir(testDir, "dir1")
+	runTest(t, [][]string{
+		{"profs", "add", dirToAdd1, "--profile", "work"},
+		{"profs", "add-profile", "personal"},
+	}, func(t *testing.T, pan any, err error) {
+		checkNoFailures(t, pan, err)
+
+		storageDir := dirToAdd1 + ".profs"
+		write := func(profile string, name string, content string) {
+			if err := os.WriteFile(filepath.Join(storageDir, profile, name), []byte(content), 0600); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+		write("work", "config", "current-context: acme\ntoken: acme-secret\n")
+		write("personal", "config", "current-context: home\n")
+		write("personal", "id_ed25519_acme", "acme key")
+
+		grepResult := runJSON[internal.GrepResult](t, "profs", "grep", "ACME", "-i")
+		// secret values and files are never searched
+		wantMatches := []internal.GrepMatchResult{{Profile: "work", Path: filepath.Join(dirToAdd1, "config"), Line: 1, Text: "current-context: acme"}}
+		if diff := cmp.Diff(grepResult.Matches, wantMatches); diff != "" {
+			t.Fatalf("Unexpected grep result (-got +want):\n%s", diff)
+		}
+		wantSkipped := []internal.GrepSkippedResult{{Profile: "personal", Path: filepath.Join(dirToAdd1, "id_ed25519_acme")}}
+		if diff := cmp.Diff(grepResult.Skipped, wantSkipped); diff != "" {
+			t.Fatalf("Unexpected skipped files (-got +want):\n%s", diff)
+		}
+
+		// a search only finding secret files reports them, rather than just no matches
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "skipped 1 file(s) holding secrets") }()
+			os.Args = []string{"profs", "grep", "nothing-here"}
+			captureStdout(t, func() { _ = mainCmd().RunE() })
+		}()
+
+		whichResult := runJSON[internal.WhichResult](t, "profs", "which", "id_ed25519_acme")
+		wantWhich := []internal.WhichMatchResult{{Profile: "personal", Path: filepath.Join(dirToAdd1, "id_ed25519_acme")}}
+		if diff := cmp.Diff(whichResult.Matches, wantWhich); diff != "" {
+			t.Fatalf("Unexpected which result (-got +want):\n%s", diff)
+		}
+
+		func() {
+			defer func() { expectPanic(t, recover(), nil, "No profile contains") }()
+			os.Args = []string{"profs", "which", "id_rsa"}
+			_ = mainCmd().RunE()
+		}()
+	})
+}
+
 func TestList2(t *testing.T) {
 	testDir := mkTempDir()
 	defer func() { deleteDirAndContents(testDir) }()
@@ -218,6 +1671,28 @@ func expectError(t *testing.T, pan any, err error, expectedMsg string) {
 
 var testMutex = sync.Mutex{}
 
+// runCmd runs a command from a runTest verifier, failing the test if it returns an error, and returns its output
+func runCmd(t *testing.T, args ...string) string {
+	t.Helper()
+	os.Args = args
+	return captureStdout(t, func() {
+		if err := mainCmd().RunE(); err != nil {
+			t.Fatalf("Failed to run %v: %v", args, err)
+		}
+	})
+}
+
+// runJSON is like runCmd, parsing the machine-readable output of the command
+func runJSON[T any](t *testing.T, args ...string) T {
+	t.Helper()
+	var result T
+	out := runCmd(t, append(args, "-o", "json")...)
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("Failed to parse the output of %v: %v\n%s", args, err, out)
+	}
+	return result
+}
+
 // Tests must be executed in a single-threaded manner,
 // since we override global os.Args
 func runTest(
@@ -236,10 +1711,11 @@ func runTest(
 	// NOTE: TestMode must be set to true, else we will
 	// be deleting the config file in the real environment
 	defer func() {
-		configPath := internal.GlobalConfigPath()
-		if _, err := os.Stat(configPath); err == nil {
-			if err := os.Remove(configPath); err != nil {
-				t.Fatalf("Failed to remove config file: %v", err)
+		for _, path := range []string{internal.GlobalConfigPath(), internal.StateFilePath(), internal.TrashDir(), internal.SnapshotsDir(), internal.PropagatedBasesPath()} {
+			if _, err := os.Stat(path); err == nil {
+				if err := os.RemoveAll(path); err != nil {
+					t.Fatalf("Failed to remove config file: %v", err)
+				}
 			}
 		}
 	}()
@@ -263,6 +1739,56 @@ func runTest(
 	}()
 }
 
+// rewriteArchive copies an unencrypted archive, changing the content of its files with fn
+func rewriteArchive(t *testing.T, src string, dst string, fn func(name string, data []byte) []byte) {
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer func() { _ = in.Close() }()
+	zr, err := zstd.NewReader(in)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	defer zr.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer func() { _ = out.Close() }()
+	zw, err := zstd.NewWriter(out)
+	if err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	tr, tw := tar.NewReader(zr), tar.NewWriter(zw)
+	for header, err := tr.Next(); err != io.EOF; header, err = tr.Next() {
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			data = fn(header.Name, data)
+			header.Size = int64(len(data))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatalf("Failed to write archive: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+}
+
 func checkNoFailures(t *testing.T, pan any, err error) {
 	if err != nil {
 		t.Fatalf("Expected no error, got error: %v", err)
@@ -272,6 +1798,29 @@ func checkNoFailures(t *testing.T, pan any, err error) {
 	}
 }
 
+// captureStdout returns everything f writes to stdout
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	orgStdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		bytes, _ := io.ReadAll(r)
+		out <- string(bytes)
+	}()
+	func() {
+		defer func() {
+			os.Stdout = orgStdout
+			_ = w.Close()
+		}()
+		f()
+	}()
+	return <-out
+}
+
 func mkTempDir() string {
 	tempDir, err := os.MkdirTemp("", "profs-test")
 	if err != nil {